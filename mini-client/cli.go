@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// exit codes, so scripts can tell a missing task from a server that is down
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitNotFound    = 3
+	exitConflict    = 4
+	exitDenied      = 5
+	exitUnavailable = 6
+)
+
+// usageError is a mistake in the command line, it exits with exitUsage
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// exitCode maps the error a command returned to the process exit code
+func exitCode(err error) int {
+	var usage *usageError
+	var regression *regressionError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &regression):
+		return exitRegression
+	case errors.Is(err, taskclient.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, taskclient.ErrConflict):
+		return exitConflict
+	case errors.Is(err, taskclient.ErrUnauthorized), errors.Is(err, taskclient.ErrForbidden):
+		return exitDenied
+	case errors.Is(err, taskclient.ErrServer), errors.Is(err, taskclient.ErrRateLimited),
+		errors.Is(err, taskclient.ErrCircuitOpen), errors.As(err, &netErr):
+		return exitUnavailable
+	}
+	return exitError
+}
+
+// options are the flags every subcommand takes. The settings in effect come from the defaults,
+// then the context (profile) in use, then TASK_MANAGER_* environment variables and finally flags.
+type options struct {
+	Profile         // settings in effect, filled in by resolve
+	flags   Profile // only what was given on the command line
+	context string
+
+	retries          int
+	retryBaseDelay   time.Duration
+	retryMaxDelay    time.Duration
+	breakerThreshold int
+	breakerTimeout   time.Duration
+
+	metricSinks    string
+	metricTags     string
+	statsdAddr     string
+	prometheusAddr string
+	metrics        Metrics // built by run from the flags above
+
+	tracingBackend string
+	otlpEndpoint   string
+	tracer         Tracer // built by run from the flags above
+
+	queue bool // set by the commands that can be queued
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.context, "context", "", "context from the config file to use instead of the current one (env "+envPrefix+"CONTEXT)")
+	fs.StringVar(&o.flags.URL, "url", "", "task-manager URL, default http://localhost:9000 (env "+envPrefix+"URL)")
+	fs.StringVar(&o.flags.APIKey, "api-key", "", "API key sent to task-manager (env "+envPrefix+"API_KEY)")
+	fs.StringVar(&o.flags.Tenant, "tenant", "", "tenant to work in (env "+envPrefix+"TENANT)")
+	fs.StringVar(&o.flags.List, "list", "", "task list to work on, the default list if empty (env "+envPrefix+"LIST)")
+	fs.StringVar(&o.flags.CACert, "ca-cert", "", "CA bundle used to verify task-manager's certificate (env "+envPrefix+"CA_CERT)")
+	fs.StringVar(&o.flags.ClientCert, "client-cert", "", "client certificate presented to task-manager for mTLS (env "+envPrefix+"CLIENT_CERT)")
+	fs.StringVar(&o.flags.ClientKey, "client-key", "", "private key of the client certificate (env "+envPrefix+"CLIENT_KEY)")
+	fs.DurationVar(&o.flags.Timeout, "timeout", 0, "timeout of each request to task-manager, default 10s (env "+envPrefix+"TIMEOUT)")
+	fs.StringVar(&o.flags.Output, "output", "", "output format: table (default), json or plain (env "+envPrefix+"OUTPUT)")
+	fs.StringVar(&o.flags.Output, "o", "", "shorthand for -output")
+	fs.IntVar(&o.retries, "retries", 4, "attempts per call including the first, 1 disables retries")
+	fs.DurationVar(&o.retryBaseDelay, "retry-base-delay", 100*time.Millisecond, "backoff before the first retry, doubled for each one after")
+	fs.DurationVar(&o.retryMaxDelay, "retry-max-delay", 5*time.Second, "longest backoff between retries")
+	fs.IntVar(&o.breakerThreshold, "breaker-threshold", 5, "consecutive failures that open the circuit breaker, 0 disables it")
+	fs.DurationVar(&o.breakerTimeout, "breaker-timeout", 30*time.Second, "how long the breaker stays open before probing the server")
+	fs.StringVar(&o.metricSinks, "metrics", defaultSinks(os.Getenv), "comma separated metrics sinks: statsd, prometheus, stdout or none, statsd by default when DD_AGENT_HOST is set (env "+envPrefix+"METRICS)")
+	fs.StringVar(&o.metricTags, "metric-tags", envOr(envPrefix+"METRIC_TAGS", "environment:dev"), "comma separated key:value tags sent with every metric (env "+envPrefix+"METRIC_TAGS)")
+	fs.StringVar(&o.statsdAddr, "statsd-addr", "", "DogStatsD agent address, default DD_AGENT_HOST and DD_DOGSTATSD_PORT")
+	fs.StringVar(&o.prometheusAddr, "metrics-addr", "localhost:9102", "address the prometheus sink serves /metrics on")
+	fs.StringVar(&o.tracingBackend, "tracing", envOr(envPrefix+"TRACING", "none"), "tracing backend: otel or none, requests carry W3C and Datadog trace headers when on (env "+envPrefix+"TRACING)")
+	fs.StringVar(&o.otlpEndpoint, "otlp-endpoint", envOr(envPrefix+"OTLP_ENDPOINT", "http://localhost:4318"), "OTLP/HTTP collector the otel tracer exports to (env "+envPrefix+"OTLP_ENDPOINT)")
+}
+
+// registerQueue adds -queue to commands that change tasks
+func (o *options) registerQueue(fs *flag.FlagSet) {
+	fs.BoolVar(&o.queue, "queue", true, "queue the change in the local journal if task-manager is unreachable, tasks sync replays it (env "+envPrefix+"QUEUE sets the journal path)")
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// resolve works out the settings in effect, withProfile false skips the config file
+func (o *options) resolve(withProfile bool) error {
+	o.Profile = defaultProfile()
+	if withProfile {
+		config, err := loadConfig(configPath(os.Getenv))
+		if err != nil {
+			return err
+		}
+		name := o.context
+		if name == "" {
+			name = os.Getenv(envPrefix + "CONTEXT")
+		}
+		_, profile, err := config.profile(name)
+		if err != nil {
+			return err
+		}
+		o.Profile.merge(profile)
+	}
+	env, err := envProfile(os.Getenv)
+	if err != nil {
+		return err
+	}
+	o.Profile.merge(env)
+	o.Profile.merge(o.flags)
+	return nil
+}
+
+// client builds the task client the options describe
+func (o *options) client() (*taskclient.Client, error) {
+	tlsConf, err := tlsConfig(o.CACert, o.ClientCert, o.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	tasks := taskclient.New(o.URL)
+	tasks.APIKey = o.APIKey
+	tasks.Tenant = o.Tenant
+	tasks.List = o.List
+	tasks.HTTPClient = &http.Client{Timeout: o.Timeout, Transport: transport}
+	tasks.Retry = taskclient.RetryPolicy{MaxAttempts: o.retries, BaseDelay: o.retryBaseDelay, MaxDelay: o.retryMaxDelay}
+	if o.breakerThreshold > 0 {
+		tasks.Breaker = taskclient.NewBreaker(o.breakerThreshold, o.breakerTimeout)
+	}
+	if o.metrics != nil {
+		tasks.Metrics = o.metrics
+	}
+	if o.tracer != nil {
+		tasks.Tracer = o.tracer
+	}
+	return tasks, nil
+}
+
+// cli is what a running subcommand writes its results to
+type cli struct {
+	out    io.Writer
+	format string
+}
+
+type runFunc func(ctx context.Context, cli *cli, args []string) error
+
+// command is one subcommand, setup registers its own flags and returns the function running it.
+// Commands that do not talk to a server set local, no context is loaded for them so a broken
+// config file cannot lock them out.
+type command struct {
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet, opts *options) runFunc
+	local   bool
+}
+
+var commands = map[string]command{
+	"add":      {"TITLE...", "add a task", addCommand, false},
+	"list":     {"", "list tasks", listCommand, false},
+	"get":      {"ID", "show a single task", getCommand, false},
+	"complete": {"ID", "mark a task completed", completeCommand, false},
+	"reopen":   {"ID", "mark a completed task as not completed", reopenCommand, false},
+	"delete":   {"ID", "delete a task", deleteCommand, false},
+	"clear":    {"", "delete every task in the list, needs -yes", clearCommand, false},
+	"edit":     {"ID", "change a task's title, description or assignees", editCommand, false},
+	"sync":     {"", "replay the changes queued while task-manager was unreachable, -status shows them", syncCommand, false},
+	"loadgen":  {"", "keep adding, listing and completing tasks", loadgenCommand, false},
+	"context":  {"list | current | use NAME | set NAME | delete NAME", "manage the contexts (profiles) in the config file", contextCommand, true},
+	"report":   {"show FILE | compare BASE CANDIDATE", "show a saved loadgen report or flag regressions between two", reportCommand, true},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, "usage: tasks COMMAND [flags] [args]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-9s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprint(w, "\nrun tasks COMMAND -h for its flags\n\n")
+	fmt.Fprint(w, "exit codes: 0 ok, 1 error, 2 usage, 3 not found, 4 conflict, 5 unauthorized or forbidden, 6 server unavailable, 7 report regressed\n")
+}
+
+// parseInterspersed parses flags wherever they appear, so both "complete -o json 3" and
+// "complete 3 -o json" work, and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// run runs the subcommand named by args[0] and returns the exit code
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage(stdout)
+		return exitOK
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "tasks: unknown command %q\n\n", name)
+		usage(stderr)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("tasks "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: tasks %s [flags] %s\n\n%s\n\nflags:\n", name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	opts := &options{}
+	opts.register(fs)
+	runCmd := cmd.setup(fs, opts)
+	positional, err := parseInterspersed(fs, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	if err := opts.resolve(!cmd.local); err != nil {
+		fmt.Fprintf(stderr, "tasks %s: %v\n", name, err)
+		return exitCode(err)
+	}
+	switch opts.Output {
+	case "table", "json", "plain":
+	default:
+		fmt.Fprintf(stderr, "tasks %s: unknown output format %q, use table, json or plain\n", name, opts.Output)
+		return exitUsage
+	}
+
+	if !cmd.local {
+		opts.metrics, err = newMetrics(opts.metricSinks, metricsOptions{
+			StatsdAddr:     opts.statsdAddr,
+			PrometheusAddr: opts.prometheusAddr,
+			Tags:           parseTags(opts.metricTags),
+			Stdout:         stdout,
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "tasks %s: %v\n", name, err)
+			return exitUsage
+		}
+		defer opts.metrics.Close()
+		opts.tracer, err = newTracer(opts.tracingBackend, tracingOptions{
+			OTLPEndpoint: opts.otlpEndpoint,
+			Tags:         parseTags(opts.metricTags),
+			Errors:       stderr,
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "tasks %s: %v\n", name, err)
+			return exitUsage
+		}
+		defer opts.tracer.Stop()
+	}
+
+	err = runCmd(ctx, &cli{out: stdout, format: opts.Output}, positional)
+	if err != nil {
+		fmt.Fprintf(stderr, "tasks %s: %v\n", name, err)
+		var usage *usageError
+		if errors.As(err, &usage) {
+			fs.Usage()
+		}
+	}
+	return exitCode(err)
+}
+
+// taskID reads the single ID argument of commands working on one task
+func taskID(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, usageErrorf("expected a single task ID")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, usageErrorf("task ID must be a number, got %q", args[0])
+	}
+	return id, nil
+}
+
+func addCommand(fs *flag.FlagSet, opts *options) runFunc {
+	id := fs.Int64("id", 0, "ID of the new task, one more than the highest visible ID if 0, worked out on sync if the add is queued")
+	description := fs.String("description", "", "description of the task")
+	assignees := fs.String("assignees", "", "comma separated users to assign the task to")
+	opts.registerQueue(fs)
+	return func(ctx context.Context, cli *cli, args []string) error {
+		if len(args) == 0 {
+			return usageErrorf("a title is required")
+		}
+		task := taskclient.Task{Id: *id, Title: strings.Join(args, " "), Description: *description}
+		if *assignees != "" {
+			task.Assignees = strings.Split(*assignees, ",")
+		}
+		return opts.change(ctx, cli, &queuedOp{Op: queueAdd, Task: &task})
+	}
+}
+
+func listCommand(fs *flag.FlagSet, opts *options) runFunc {
+	showCompleted := fs.Bool("show-completed", false, "include completed tasks")
+	owner := fs.String("owner", "", "only tasks owned by this user, e.g. user:alice")
+	assignee := fs.String("assignee", "", "only tasks assigned to this user")
+	search := fs.String("search", "", "only tasks whose title or description contains this text")
+	return func(ctx context.Context, cli *cli, args []string) error {
+		if len(args) != 0 {
+			return usageErrorf("list takes no arguments")
+		}
+		tasks, err := opts.client()
+		if err != nil {
+			return err
+		}
+		all, err := tasks.ListTasks(ctx, *showCompleted)
+		if err != nil {
+			return err
+		}
+		opts.remember(*showCompleted, all...)
+		matching := []taskclient.Task{}
+		for _, task := range all {
+			if *owner != "" && task.Owner != *owner {
+				continue
+			}
+			if *assignee != "" && !containsString(task.Assignees, *assignee) {
+				continue
+			}
+			if *search != "" && !strings.Contains(task.Title, *search) && !strings.Contains(task.Description, *search) {
+				continue
+			}
+			matching = append(matching, task)
+		}
+		return cli.writeTasks(matching)
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func getCommand(fs *flag.FlagSet, opts *options) runFunc {
+	return func(ctx context.Context, cli *cli, args []string) error {
+		id, err := taskID(args)
+		if err != nil {
+			return err
+		}
+		tasks, err := opts.client()
+		if err != nil {
+			return err
+		}
+		task, err := tasks.GetTask(ctx, id)
+		if err != nil {
+			return err
+		}
+		opts.remember(false, task)
+		return cli.writeTask(task)
+	}
+}
+
+func completeCommand(fs *flag.FlagSet, opts *options) runFunc {
+	opts.registerQueue(fs)
+	return func(ctx context.Context, cli *cli, args []string) error {
+		id, err := taskID(args)
+		if err != nil {
+			return err
+		}
+		return opts.change(ctx, cli, &queuedOp{Op: queueComplete, ID: id})
+	}
+}
+
+func reopenCommand(fs *flag.FlagSet, opts *options) runFunc {
+	opts.registerQueue(fs)
+	return func(ctx context.Context, cli *cli, args []string) error {
+		id, err := taskID(args)
+		if err != nil {
+			return err
+		}
+		completed := false
+		return opts.change(ctx, cli, &queuedOp{Op: queueEdit, Edit: &taskclient.TaskEdit{Id: id, Completed: &completed}})
+	}
+}
+
+func editCommand(fs *flag.FlagSet, opts *options) runFunc {
+	title := fs.String("title", "", "new title")
+	description := fs.String("description", "", "new description")
+	assignees := fs.String("assignees", "", "comma separated users the task is assigned to, replacing the current ones")
+	opts.registerQueue(fs)
+	return func(ctx context.Context, cli *cli, args []string) error {
+		id, err := taskID(args)
+		if err != nil {
+			return err
+		}
+		edit := taskclient.TaskEdit{Id: id}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "title":
+				edit.Title = title
+			case "description":
+				edit.Description = description
+			case "assignees":
+				users := []string{}
+				if *assignees != "" {
+					users = strings.Split(*assignees, ",")
+				}
+				edit.Assignees = &users
+			}
+		})
+		if edit.Title == nil && edit.Description == nil && edit.Assignees == nil {
+			return usageErrorf("nothing to change, pass -title, -description or -assignees")
+		}
+		return opts.change(ctx, cli, &queuedOp{Op: queueEdit, Edit: &edit})
+	}
+}
+
+// change applies an add, complete or edit, or queues it, and prints the outcome. When queued
+// operations it replayed first conflicted it still prints the outcome before failing.
+func (o *options) change(ctx context.Context, cli *cli, op *queuedOp) error {
+	tasks, err := o.client()
+	if err != nil {
+		return err
+	}
+	task, queued, err := o.runOrQueue(ctx, tasks, op)
+	if err != nil && (queued || !errors.As(err, new(*syncConflictError))) {
+		return err
+	}
+	if writeErr := cli.writeApplied(op, task, queued); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func deleteCommand(fs *flag.FlagSet, opts *options) runFunc {
+	return func(ctx context.Context, cli *cli, args []string) error {
+		id, err := taskID(args)
+		if err != nil {
+			return err
+		}
+		tasks, err := opts.client()
+		if err != nil {
+			return err
+		}
+		if err := tasks.DeleteTask(ctx, id); err != nil {
+			return err
+		}
+		return cli.writeResult(fmt.Sprintf("Deleted task %d", id), map[string]interface{}{"id": id, "deleted": true})
+	}
+}
+
+func clearCommand(fs *flag.FlagSet, opts *options) runFunc {
+	yes := fs.Bool("yes", false, "really delete every task in the list")
+	return func(ctx context.Context, cli *cli, args []string) error {
+		if len(args) != 0 {
+			return usageErrorf("clear takes no arguments")
+		}
+		if !*yes {
+			return usageErrorf("clear deletes every task in the list, pass -yes to confirm")
+		}
+		tasks, err := opts.client()
+		if err != nil {
+			return err
+		}
+		if err := tasks.ClearTasks(ctx); err != nil {
+			return err
+		}
+		return cli.writeResult("Cleared all tasks", map[string]interface{}{"cleared": true})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// fakeServer serves a fixed default list the way task-manager does for JSON callers
+func fakeServer() *httptest.Server {
+	tasks := []taskclient.Task{
+		{Id: 1, Title: "write docs", Owner: "user:alice", Completed: true},
+		{Id: 2, Title: "fix bug", Owner: "user:bob", Assignees: []string{"user:alice"}},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/tasks":
+			if req.Method == http.MethodDelete {
+				res.WriteHeader(http.StatusNoContent)
+				return
+			}
+			visible := []taskclient.Task{}
+			for _, task := range tasks {
+				if req.URL.Query().Get("showCompleted") == "true" || !task.Completed {
+					visible = append(visible, task)
+				}
+			}
+			json.NewEncoder(res).Encode(visible)
+		case "/tasks/add":
+			var task taskclient.Task
+			json.NewDecoder(req.Body).Decode(&task)
+			res.WriteHeader(http.StatusCreated)
+			json.NewEncoder(res).Encode(task)
+		case "/tasks/complete":
+			http.Error(res, "No task with ID = 9 to complete", http.StatusNotFound)
+		default:
+			http.NotFound(res, req)
+		}
+	}))
+}
+
+// TestMain keeps the tests away from the config file of whoever runs them
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tasks")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(envPrefix+"CONFIG", filepath.Join(dir, "config.yaml"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestListOutputs(t *testing.T) {
+	srv := fakeServer()
+	defer srv.Close()
+
+	code, out, _ := runCLI("list", "-url", srv.URL, "-show-completed")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "ID  TITLE       DONE  OWNER       ASSIGNEES\n"+
+		"1   write docs  yes   user:alice  \n"+
+		"2   fix bug           user:bob    user:alice\n", out)
+
+	code, out, _ = runCLI("list", "-url", srv.URL, "-o", "plain")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "2\tfalse\tfix bug\n", out)
+
+	code, out, _ = runCLI("list", "-url", srv.URL, "--show-completed", "--assignee", "user:alice", "--output", "json")
+	assert.Equal(t, exitOK, code)
+	var tasks []taskclient.Task
+	assert.Nil(t, json.Unmarshal([]byte(out), &tasks))
+	assert.Equal(t, []taskclient.Task{{Id: 2, Title: "fix bug", Owner: "user:bob", Assignees: []string{"user:alice"}}}, tasks)
+}
+
+func TestAddPicksNextID(t *testing.T) {
+	srv := fakeServer()
+	defer srv.Close()
+
+	code, out, _ := runCLI("add", "-url", srv.URL, "buy", "milk", "-o", "json")
+	assert.Equal(t, exitOK, code)
+	var task taskclient.Task
+	assert.Nil(t, json.Unmarshal([]byte(out), &task))
+	assert.Equal(t, taskclient.Task{Id: 3, Title: "buy milk"}, task)
+}
+
+func TestExitCodes(t *testing.T) {
+	srv := fakeServer()
+	defer srv.Close()
+
+	code, _, stderr := runCLI("complete", "9", "-url", srv.URL)
+	assert.Equal(t, exitNotFound, code)
+	assert.Contains(t, stderr, "No task with ID = 9 to complete")
+
+	code, _, _ = runCLI("get", "7", "-url", srv.URL)
+	assert.Equal(t, exitNotFound, code)
+
+	code, _, stderr = runCLI("clear", "-url", srv.URL)
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "pass -yes to confirm")
+	code, out, _ := runCLI("clear", "-url", srv.URL, "-yes")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "Cleared all tasks\n", out)
+
+	code, _, _ = runCLI("complete", "abc", "-url", srv.URL)
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("list", "-url", srv.URL, "-o", "yaml")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("frobnicate")
+	assert.Equal(t, exitUsage, code)
+
+	srv.Close()
+	code, _, _ = runCLI("list", "-url", srv.URL, "-retries", "1")
+	assert.Equal(t, exitUnavailable, code)
+}
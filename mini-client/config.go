@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "TASK_MANAGER_"
+
+// Profile is how to reach one task-manager. In a profile empty fields are unset and fall back to
+// the defaults.
+type Profile struct {
+	URL        string        `yaml:"url,omitempty" json:"url,omitempty"`
+	APIKey     string        `yaml:"api-key,omitempty" json:"api-key,omitempty"`
+	Tenant     string        `yaml:"tenant,omitempty" json:"tenant,omitempty"`
+	List       string        `yaml:"list,omitempty" json:"list,omitempty"`
+	Output     string        `yaml:"output,omitempty" json:"output,omitempty"`
+	Timeout    time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	CACert     string        `yaml:"ca-cert,omitempty" json:"ca-cert,omitempty"`
+	ClientCert string        `yaml:"client-cert,omitempty" json:"client-cert,omitempty"`
+	ClientKey  string        `yaml:"client-key,omitempty" json:"client-key,omitempty"`
+}
+
+func defaultProfile() Profile {
+	return Profile{URL: "http://localhost:9000", Output: "table", Timeout: 10 * time.Second}
+}
+
+// merge overrides p with every field that is set in o
+func (p *Profile) merge(o Profile) {
+	set := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	set(&p.URL, o.URL)
+	set(&p.APIKey, o.APIKey)
+	set(&p.Tenant, o.Tenant)
+	set(&p.List, o.List)
+	set(&p.Output, o.Output)
+	set(&p.CACert, o.CACert)
+	set(&p.ClientCert, o.ClientCert)
+	set(&p.ClientKey, o.ClientKey)
+	if o.Timeout != 0 {
+		p.Timeout = o.Timeout
+	}
+}
+
+// envProfile reads the TASK_MANAGER_* variables that override single fields of the profile in use
+func envProfile(getenv func(string) string) (Profile, error) {
+	p := Profile{
+		URL:        getenv(envPrefix + "URL"),
+		APIKey:     getenv(envPrefix + "API_KEY"),
+		Tenant:     getenv(envPrefix + "TENANT"),
+		List:       getenv(envPrefix + "LIST"),
+		Output:     getenv(envPrefix + "OUTPUT"),
+		CACert:     getenv(envPrefix + "CA_CERT"),
+		ClientCert: getenv(envPrefix + "CLIENT_CERT"),
+		ClientKey:  getenv(envPrefix + "CLIENT_KEY"),
+	}
+	if v := getenv(envPrefix + "TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return p, fmt.Errorf("%sTIMEOUT: %w", envPrefix, err)
+		}
+		p.Timeout = timeout
+	}
+	return p, nil
+}
+
+// ClientConfig is the config file, named profiles (contexts) and which one is in use
+type ClientConfig struct {
+	CurrentContext string             `yaml:"current-context,omitempty"`
+	Contexts       map[string]Profile `yaml:"contexts,omitempty"`
+
+	path string
+}
+
+// configPath is $TASK_MANAGER_CONFIG or config.yaml in the user's config directory
+func configPath(getenv func(string) string) string {
+	if path := getenv(envPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".tasks.yaml"
+	}
+	return filepath.Join(dir, "tasks", "config.yaml")
+}
+
+// loadConfig reads the config file, a missing file is an empty config
+func loadConfig(path string) (*ClientConfig, error) {
+	config := &ClientConfig{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// save writes the config back, readable only by the user since it holds API keys
+func (c *ClientConfig) save() error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0o600)
+}
+
+// profile returns the named context, or the current one if name is empty. With neither there is
+// no profile and only the defaults apply.
+func (c *ClientConfig) profile(name string) (string, Profile, error) {
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" {
+		return "", Profile{}, nil
+	}
+	p, ok := c.Contexts[name]
+	if !ok {
+		return name, p, usageErrorf("no context named %q in %s", name, c.path)
+	}
+	return name, p, nil
+}
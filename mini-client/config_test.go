@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	t.Setenv(envPrefix+"CONFIG", path)
+	return path
+}
+
+const testConfig = `current-context: dev
+contexts:
+  dev:
+    url: http://localhost:9000
+    api-key: tm_dev_secret
+    timeout: 2s
+  staging:
+    url: https://staging.example.com
+    tenant: teamA
+    list: work
+    output: json
+`
+
+func TestSettingsPrecedence(t *testing.T) {
+	writeConfig(t, testConfig)
+
+	opts := &options{}
+	assert.Nil(t, opts.resolve(true))
+	assert.Equal(t, Profile{URL: "http://localhost:9000", APIKey: "tm_dev_secret", Output: "table", Timeout: 2 * time.Second}, opts.Profile)
+
+	// the environment overrides single fields of the profile, flags override both
+	t.Setenv(envPrefix+"CONTEXT", "staging")
+	t.Setenv(envPrefix+"TENANT", "teamB")
+	t.Setenv(envPrefix+"TIMEOUT", "5s")
+	opts = &options{flags: Profile{List: "home"}}
+	assert.Nil(t, opts.resolve(true))
+	assert.Equal(t, Profile{URL: "https://staging.example.com", Tenant: "teamB", List: "home", Output: "json", Timeout: 5 * time.Second}, opts.Profile)
+
+	opts = &options{context: "nope"}
+	assert.Equal(t, exitUsage, exitCode(opts.resolve(true)))
+}
+
+func TestNoConfigFileUsesDefaults(t *testing.T) {
+	t.Setenv(envPrefix+"CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	opts := &options{}
+	assert.Nil(t, opts.resolve(true))
+	assert.Equal(t, defaultProfile(), opts.Profile)
+}
+
+func TestConfigRejectsUnknownFields(t *testing.T) {
+	path := writeConfig(t, "contexts:\n  dev:\n    uri: http://localhost:9000\n")
+	_, err := loadConfig(path)
+	assert.NotNil(t, err)
+}
+
+func TestContextCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks", "config.yaml")
+	t.Setenv(envPrefix+"CONFIG", path)
+
+	code, out, _ := runCLI("context", "set", "local", "-url", "http://127.0.0.1:9000", "-api-key", "tm_me_secret", "-timeout", "3s")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "Saved context local")
+	code, _, _ = runCLI("context", "set", "prod", "-url", "https://tasks.example.com", "-tenant", "teamA")
+	assert.Equal(t, exitOK, code)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	config, err := loadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "local", config.CurrentContext)
+	assert.Equal(t, Profile{URL: "http://127.0.0.1:9000", APIKey: "tm_me_secret", Timeout: 3 * time.Second}, config.Contexts["local"])
+
+	code, out, _ = runCLI("context", "list")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "CURRENT  NAME   URL                        TENANT  LIST\n"+
+		"*        local  http://127.0.0.1:9000              \n"+
+		"         prod   https://tasks.example.com  teamA   \n", out)
+
+	code, _, _ = runCLI("context", "use", "prod")
+	assert.Equal(t, exitOK, code)
+	code, out, _ = runCLI("context", "current")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "context      prod\n")
+	assert.Contains(t, out, "url          https://tasks.example.com\n")
+
+	code, out, _ = runCLI("context", "current", "-context", "local")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "api-key      tm_me_****\n")
+
+	code, _, _ = runCLI("context", "use", "missing")
+	assert.Equal(t, exitUsage, code)
+	code, _, _ = runCLI("context", "delete", "prod")
+	assert.Equal(t, exitOK, code)
+	config, err = loadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "", config.CurrentContext)
+	assert.Len(t, config.Contexts, 1)
+}
+
+func TestMaskAPIKey(t *testing.T) {
+	assert.Equal(t, "", maskAPIKey(""))
+	assert.Equal(t, "tm_abc_****", maskAPIKey("tm_abc_secret"))
+	assert.Equal(t, "****", maskAPIKey("opaque"))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// maskAPIKey keeps the id of a tm_<id>_<secret> key so it can still be told apart from others
+func maskAPIKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if i := strings.LastIndex(key, "_"); strings.HasPrefix(key, "tm_") && i > len("tm_") {
+		return key[:i+1] + "****"
+	}
+	return "****"
+}
+
+// contextCommand lists, shows, switches, adds and deletes the contexts in the config file
+func contextCommand(fs *flag.FlagSet, opts *options) runFunc {
+	return func(ctx context.Context, cli *cli, args []string) error {
+		if len(args) == 0 {
+			args = []string{"list"}
+		}
+		config, err := loadConfig(configPath(os.Getenv))
+		if err != nil {
+			return err
+		}
+		action, args := args[0], args[1:]
+		name := ""
+		switch action {
+		case "list", "current":
+			if len(args) != 0 {
+				return usageErrorf("context %s takes no arguments", action)
+			}
+		case "use", "set", "delete":
+			if len(args) != 1 {
+				return usageErrorf("context %s takes the name of a context", action)
+			}
+			name = args[0]
+		default:
+			return usageErrorf("unknown context action %q", action)
+		}
+
+		switch action {
+		case "list":
+			return cli.writeContexts(config)
+		case "current":
+			if err := opts.resolve(true); err != nil {
+				return err
+			}
+			current := opts.context
+			if current == "" {
+				current = os.Getenv(envPrefix + "CONTEXT")
+			}
+			if current == "" {
+				current = config.CurrentContext
+			}
+			effective := opts.Profile
+			effective.APIKey = maskAPIKey(effective.APIKey)
+			return cli.writeProfile(current, effective)
+		case "use":
+			if _, _, err := config.profile(name); err != nil {
+				return err
+			}
+			config.CurrentContext = name
+			if err := config.save(); err != nil {
+				return err
+			}
+			return cli.writeResult(fmt.Sprintf("Switched to context %s", name), map[string]string{"current-context": name})
+		case "set":
+			if config.Contexts == nil {
+				config.Contexts = make(map[string]Profile)
+			}
+			profile := config.Contexts[name]
+			profile.merge(opts.flags)
+			config.Contexts[name] = profile
+			if config.CurrentContext == "" {
+				config.CurrentContext = name
+			}
+			if err := config.save(); err != nil {
+				return err
+			}
+			return cli.writeResult(fmt.Sprintf("Saved context %s to %s", name, config.path), map[string]string{"context": name})
+		}
+
+		// delete
+		if _, _, err := config.profile(name); err != nil {
+			return err
+		}
+		delete(config.Contexts, name)
+		if config.CurrentContext == name {
+			config.CurrentContext = ""
+		}
+		if err := config.save(); err != nil {
+			return err
+		}
+		return cli.writeResult(fmt.Sprintf("Deleted context %s", name), map[string]interface{}{"context": name, "deleted": true})
+	}
+}
+
+// writeContexts prints every context, marking the current one
+func (c *cli) writeContexts(config *ClientConfig) error {
+	names := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	switch c.format {
+	case "json":
+		contexts := make(map[string]Profile, len(config.Contexts))
+		for name, p := range config.Contexts {
+			p.APIKey = maskAPIKey(p.APIKey)
+			contexts[name] = p
+		}
+		return c.writeJSON(map[string]interface{}{"current-context": config.CurrentContext, "contexts": contexts})
+	case "plain":
+		for _, name := range names {
+			fmt.Fprintln(c.out, name)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CURRENT\tNAME\tURL\tTENANT\tLIST")
+	for _, name := range names {
+		current := ""
+		if name == config.CurrentContext {
+			current = "*"
+		}
+		p := config.Contexts[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", current, name, p.URL, p.Tenant, p.List)
+	}
+	return w.Flush()
+}
+
+// writeProfile prints the settings in effect, name is the context they came from, if any
+func (c *cli) writeProfile(name string, p Profile) error {
+	if c.format == "json" {
+		return c.writeJSON(map[string]interface{}{"context": name, "settings": p})
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "context\t%s\n", name)
+	fmt.Fprintf(w, "url\t%s\n", p.URL)
+	fmt.Fprintf(w, "api-key\t%s\n", p.APIKey)
+	fmt.Fprintf(w, "tenant\t%s\n", p.Tenant)
+	fmt.Fprintf(w, "list\t%s\n", p.List)
+	fmt.Fprintf(w, "output\t%s\n", p.Output)
+	fmt.Fprintf(w, "timeout\t%s\n", p.Timeout)
+	fmt.Fprintf(w, "ca-cert\t%s\n", p.CACert)
+	fmt.Fprintf(w, "client-cert\t%s\n", p.ClientCert)
+	fmt.Fprintf(w, "client-key\t%s\n", p.ClientKey)
+	return w.Flush()
+}
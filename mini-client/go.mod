@@ -2,12 +2,20 @@ module github.com/DataDog/mini-golang-project/mini-client
 
 go 1.20
 
-require github.com/DataDog/datadog-go v4.8.3+incompatible
+require (
+	github.com/DataDog/datadog-go v4.8.3+incompatible
+	github.com/DataDog/mini-golang-project/telemetry v0.0.0
+	github.com/stretchr/testify v1.8.4
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/Microsoft/go-winio v0.6.1 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/mod v0.8.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/tools v0.6.0 // indirect
 )
+
+replace github.com/DataDog/mini-golang-project/telemetry => ../telemetry
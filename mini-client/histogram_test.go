@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	var h histogram
+	for v := 1; v <= 10000; v++ {
+		h.record(time.Duration(v) * time.Microsecond)
+	}
+	assert.Equal(t, int64(10000), h.total)
+	assert.InEpsilon(t, 5000, h.percentile(50).Microseconds(), 0.02)
+	assert.InEpsilon(t, 9000, h.percentile(90).Microseconds(), 0.02)
+	assert.InEpsilon(t, 9900, h.percentile(99).Microseconds(), 0.02)
+	assert.Equal(t, 10*time.Millisecond, h.percentile(100))
+	assert.Equal(t, time.Microsecond, h.percentile(0))
+	assert.Equal(t, 5000*time.Microsecond, h.mean())
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	// every value is at most 1/64 below the top of its bucket
+	for _, v := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 123456, 1 << 40} {
+		high := bucketHigh(bucketIndex(v))
+		assert.GreaterOrEqual(t, high, v)
+		assert.LessOrEqual(t, float64(high-v), float64(v)/64, "value %d", v)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b histogram
+	a.record(time.Millisecond)
+	b.record(3 * time.Millisecond)
+	b.record(time.Second)
+	a.merge(&b)
+	assert.Equal(t, int64(3), a.total)
+	assert.Equal(t, int64(1000), a.min)
+	assert.Equal(t, int64(1000000), a.max)
+	assert.InEpsilon(t, 3000, a.percentile(50).Microseconds(), 0.02)
+
+	var empty histogram
+	assert.Equal(t, time.Duration(0), empty.percentile(99))
+}
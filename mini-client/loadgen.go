@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+const (
+	opAdd      = "add"
+	opList     = "list"
+	opComplete = "complete"
+	opDelete   = "delete"
+
+	// opScenario is what whole scenario iterations are reported as
+	opScenario = "scenario"
+)
+
+// opMix picks operations at random in proportion to their weights
+type opMix struct {
+	ops     []string
+	weights []int
+	total   int
+}
+
+// parseMix reads a mix like "add=4,list=4,complete=1,delete=1"
+func parseMix(s string) (opMix, error) {
+	var mix opMix
+	for _, part := range strings.Split(s, ",") {
+		op, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if known := op == opAdd || op == opList || op == opComplete || op == opDelete; !ok || !known {
+			return mix, fmt.Errorf("mix entry %q is not op=weight with op add, list, complete or delete", part)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return mix, fmt.Errorf("weight of %s must be a number of at least 0", op)
+		}
+		mix.ops = append(mix.ops, op)
+		mix.weights = append(mix.weights, w)
+		mix.total += w
+	}
+	if mix.total == 0 {
+		return mix, errors.New("mix needs at least one operation with a positive weight")
+	}
+	return mix, nil
+}
+
+func (m opMix) pick(r *rand.Rand) string {
+	n := r.Intn(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.ops[i]
+		}
+		n -= w
+	}
+	return m.ops[len(m.ops)-1]
+}
+
+// rateSchedule is the target request rate, going linearly from start to end over ramp
+type rateSchedule struct {
+	start float64
+	end   float64
+	ramp  time.Duration
+}
+
+// open is true when requests follow the schedule rather than the workers' pace
+func (s rateSchedule) open() bool {
+	return s.start > 0 || s.end > 0
+}
+
+func (s rateSchedule) at(elapsed time.Duration) float64 {
+	if s.ramp <= 0 || elapsed >= s.ramp {
+		return s.end
+	}
+	return s.start + (s.end-s.start)*float64(elapsed)/float64(s.ramp)
+}
+
+// taskPool tracks the tasks the load generator added so complete and delete hit real tasks
+type taskPool struct {
+	mu     sync.Mutex
+	nextID int64
+	open   []int64
+	done   []int64
+}
+
+func (p *taskPool) newID() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	return p.nextID
+}
+
+func (p *taskPool) added(id int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = append(p.open, id)
+}
+
+// takeOpen removes and returns a random open task, ok is false if there is none
+func (p *taskPool) takeOpen(r *rand.Rand) (int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return takeRandom(&p.open, r)
+}
+
+func (p *taskPool) completed(id int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = append(p.done, id)
+}
+
+// takeAny removes and returns a random task, open or completed
+func (p *taskPool) takeAny(r *rand.Rand) (int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.done) > 0 && (len(p.open) == 0 || r.Intn(2) == 0) {
+		return takeRandom(&p.done, r)
+	}
+	return takeRandom(&p.open, r)
+}
+
+func takeRandom(ids *[]int64, r *rand.Rand) (int64, bool) {
+	if len(*ids) == 0 {
+		return 0, false
+	}
+	i := r.Intn(len(*ids))
+	id := (*ids)[i]
+	(*ids)[i] = (*ids)[len(*ids)-1]
+	*ids = (*ids)[:len(*ids)-1]
+	return id, true
+}
+
+// loadgen sends a mix of operations, or iterations of a scenario, from a pool of workers, at a
+// target rate or, with a zero rate, as fast as the workers can
+type loadgen struct {
+	tasks    *taskclient.Client
+	workers  int
+	rate     rateSchedule
+	mix      opMix
+	scenario *Scenario // replaces mix when set
+	duration time.Duration
+	pool     *taskPool
+	recorder *recorder
+	metrics  Metrics
+	tracer   Tracer
+}
+
+// run generates load until duration passes or ctx is done. Requests in flight when it stops are
+// allowed to finish, bounded by the client timeout, so they are not counted as errors.
+func (l *loadgen) run(ctx context.Context) *Report {
+	if l.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.duration)
+		defer cancel()
+	}
+	start := time.Now()
+	tickets := make(chan struct{}, l.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < l.workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(start.UnixNano() + int64(worker)))
+			for iteration := 0; ; iteration++ {
+				if l.rate.open() {
+					if _, ok := <-tickets; !ok {
+						return
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+				if l.scenario == nil {
+					l.do(r, l.mix.pick(r))
+					continue
+				}
+				began := time.Now()
+				err := l.iteration(ctx, r, worker, iteration)
+				if ctx.Err() == nil || err == nil {
+					l.observe(opScenario, opScenario, time.Since(began), err)
+				}
+			}
+		}(i)
+	}
+	if l.rate.open() {
+		l.schedule(ctx, start, tickets)
+		close(tickets)
+	}
+	wg.Wait()
+	return l.recorder.report(time.Since(start))
+}
+
+// schedule hands out one ticket per request at the target rate. The schedule does not wait for
+// busy workers (an open model), a ticket no worker is free to take is counted as dropped.
+func (l *loadgen) schedule(ctx context.Context, start time.Time, tickets chan<- struct{}) {
+	next := start
+	for {
+		rate := l.rate.at(next.Sub(start))
+		if rate <= 0 {
+			// idle until the ramp brings the rate above zero
+			next = next.Add(100 * time.Millisecond)
+		} else {
+			next = next.Add(time.Duration(float64(time.Second) / rate))
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if rate <= 0 {
+			continue
+		}
+		select {
+		case tickets <- struct{}{}:
+		default:
+			l.recorder.drop()
+		}
+	}
+}
+
+// tracing is the tracer to start spans with, tests leave tracer nil
+func (l *loadgen) tracing() Tracer {
+	if l.tracer == nil {
+		return noopTracer{}
+	}
+	return l.tracer
+}
+
+// do runs a single operation, complete and delete fall back to add while there are no tasks to use
+func (l *loadgen) do(r *rand.Rand, op string) {
+	var id int64
+	var ok bool
+	switch op {
+	case opComplete:
+		id, ok = l.pool.takeOpen(r)
+	case opDelete:
+		id, ok = l.pool.takeAny(r)
+	}
+	if (op == opComplete || op == opDelete) && !ok {
+		op = opAdd
+	}
+
+	ctx, span := l.tracing().StartSpan(context.Background(), "loadgen.operation")
+	defer span.Finish()
+	span.SetTag("operation", op)
+	start := time.Now()
+	var err error
+	switch op {
+	case opAdd:
+		id = l.pool.newID()
+		_, err = l.tasks.AddTask(ctx, taskclient.Task{Id: id, Title: "task #" + strconv.FormatInt(id, 10), Description: "loadgen"})
+		if err == nil {
+			l.pool.added(id)
+		}
+	case opList:
+		_, err = l.tasks.ListTasks(ctx, true)
+	case opComplete:
+		_, err = l.tasks.CompleteTask(ctx, id)
+		if err == nil || errors.Is(err, taskclient.ErrConflict) {
+			l.pool.completed(id)
+		}
+	case opDelete:
+		err = l.tasks.DeleteTask(ctx, id)
+	}
+	l.observe(op, op, time.Since(start), err)
+	if err != nil {
+		span.SetError(err)
+	}
+}
+
+// observe records a request under name, a scenario step or op itself, and sends it as
+// loadgen.operations.count and loadgen.operation.duration_seconds tagged with op and the result
+func (l *loadgen) observe(name string, op string, elapsed time.Duration, err error) {
+	l.recorder.record(name, elapsed, err)
+	if l.metrics == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = errorKind(err)
+	}
+	tags := []string{"operation:" + op, "result:" + result}
+	if name != op {
+		tags = append(tags, "step:"+name)
+	}
+	l.metrics.Count("loadgen.operations.count", 1, tags)
+	l.metrics.Histogram("loadgen.operation.duration_seconds", elapsed.Seconds(), tags)
+}
+
+// loadgenCommand drives load at the server until -duration passes or it is interrupted
+func loadgenCommand(fs *flag.FlagSet, opts *options) runFunc {
+	workers := fs.Int("workers", 4, "number of concurrent workers")
+	rate := fs.Float64("rate", 10, "target requests per second across all workers, 0 sends as fast as the workers can")
+	rampTo := fs.Float64("ramp-to", -1, "rate to ramp to linearly over -ramp, -1 keeps -rate constant")
+	ramp := fs.Duration("ramp", 0, "how long the ramp from -rate to -ramp-to takes, 0 uses -duration")
+	mix := fs.String("mix", "add=4,list=4,complete=1,delete=1", "weighted operations to send")
+	duration := fs.Duration("duration", time.Minute, "how long to run, 0 runs until interrupted")
+	firstID := fs.Int64("first-id", 1, "ID of the first task added, so several generators can share a list")
+	scenarioPath := fs.String("scenario", "", "YAML or JSON scenario to run instead of -mix, -rate then counts iterations")
+	reportPath := fs.String("report", "", "also save the report to this file, as CSV if it ends in .csv and JSON otherwise")
+	return func(ctx context.Context, cli *cli, args []string) error {
+		if len(args) != 0 {
+			return usageErrorf("loadgen takes no arguments")
+		}
+		if *workers < 1 {
+			return usageErrorf("-workers must be at least 1")
+		}
+		if *rate < 0 {
+			return usageErrorf("-rate must not be negative")
+		}
+		schedule := rateSchedule{start: *rate, end: *rate}
+		if *rampTo >= 0 {
+			schedule.end = *rampTo
+			schedule.ramp = *ramp
+			if schedule.ramp == 0 {
+				schedule.ramp = *duration
+			}
+			if schedule.ramp == 0 {
+				return usageErrorf("-ramp-to needs -ramp or -duration")
+			}
+		}
+		m, err := parseMix(*mix)
+		if err != nil {
+			return usageErrorf("-mix: %v", err)
+		}
+		var scenario *Scenario
+		if *scenarioPath != "" {
+			if scenario, err = loadScenario(*scenarioPath); err != nil {
+				return err
+			}
+		}
+		tasks, err := opts.client()
+		if err != nil {
+			return err
+		}
+		l := &loadgen{
+			tasks:    tasks,
+			workers:  *workers,
+			rate:     schedule,
+			mix:      m,
+			scenario: scenario,
+			duration: *duration,
+			pool:     &taskPool{nextID: *firstID - 1},
+			recorder: newRecorder(),
+			metrics:  opts.metrics,
+			tracer:   opts.tracer,
+		}
+		report := l.run(ctx)
+		if *reportPath != "" {
+			if err := report.writeFile(*reportPath); err != nil {
+				return err
+			}
+		}
+		return cli.writeReport(report)
+	}
+}
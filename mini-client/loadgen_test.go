@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// countingServer accepts every operation and counts the requests per path
+func countingServer() (*httptest.Server, func() map[string]int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		counts[req.URL.Path]++
+		mu.Unlock()
+		switch req.URL.Path {
+		case "/tasks":
+			res.Write([]byte(`[]`))
+		case "/tasks/delete":
+			res.WriteHeader(http.StatusNoContent)
+		default:
+			var task taskclient.Task
+			json.NewDecoder(req.Body).Decode(&task)
+			json.NewEncoder(res).Encode(task)
+		}
+	}))
+	return srv, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		copy := make(map[string]int)
+		for k, v := range counts {
+			copy[k] = v
+		}
+		return copy
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("add=3, list=1,delete=0")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"add", "list", "delete"}, mix.ops)
+	assert.Equal(t, 4, mix.total)
+
+	r := rand.New(rand.NewSource(1))
+	picked := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		picked[mix.pick(r)]++
+	}
+	assert.InDelta(t, 3000, picked["add"], 150)
+	assert.InDelta(t, 1000, picked["list"], 150)
+	assert.Equal(t, 0, picked["delete"])
+
+	for _, bad := range []string{"", "add", "fly=1", "add=-1", "add=0"} {
+		_, err := parseMix(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestRateSchedule(t *testing.T) {
+	constant := rateSchedule{start: 5, end: 5}
+	assert.Equal(t, 5.0, constant.at(time.Hour))
+	ramp := rateSchedule{start: 10, end: 30, ramp: 10 * time.Second}
+	assert.Equal(t, 10.0, ramp.at(0))
+	assert.Equal(t, 20.0, ramp.at(5*time.Second))
+	assert.Equal(t, 30.0, ramp.at(time.Minute))
+	assert.False(t, rateSchedule{}.open())
+}
+
+func TestLoadgenHoldsTargetRate(t *testing.T) {
+	srv, counts := countingServer()
+	defer srv.Close()
+
+	mix, _ := parseMix("add=1,list=1")
+	l := &loadgen{
+		tasks:    taskclient.New(srv.URL),
+		workers:  4,
+		rate:     rateSchedule{start: 100, end: 100},
+		mix:      mix,
+		duration: 500 * time.Millisecond,
+		pool:     &taskPool{},
+		recorder: newRecorder(),
+	}
+	report := l.run(context.Background())
+	sent := report.Operations[opAdd].Requests + report.Operations[opList].Requests
+	assert.InDelta(t, 50, sent, 10)
+	assert.Equal(t, int64(0), report.Operations[opAdd].Errors)
+	assert.Equal(t, int(sent), counts()["/tasks/add"]+counts()["/tasks"])
+}
+
+func TestLoadgenUsesTasksItAdded(t *testing.T) {
+	srv, counts := countingServer()
+	defer srv.Close()
+
+	mix, _ := parseMix("add=1,complete=1,delete=1")
+	l := &loadgen{
+		tasks:    taskclient.New(srv.URL),
+		workers:  2,
+		mix:      mix,
+		duration: 200 * time.Millisecond,
+		pool:     &taskPool{},
+		recorder: newRecorder(),
+	}
+	report := l.run(context.Background())
+	c := counts()
+	// complete and delete only ever touch added tasks, each at most once
+	assert.Greater(t, c["/tasks/add"], 0)
+	assert.LessOrEqual(t, c["/tasks/complete"], c["/tasks/add"])
+	assert.LessOrEqual(t, c["/tasks/delete"], c["/tasks/add"])
+	assert.Equal(t, int64(c["/tasks/add"]), report.Operations[opAdd].Requests)
+}
+
+func TestLoadgenStopsOnCancel(t *testing.T) {
+	srv, _ := countingServer()
+	defer srv.Close()
+
+	mix, _ := parseMix("list=1")
+	l := &loadgen{
+		tasks:    taskclient.New(srv.URL),
+		workers:  2,
+		rate:     rateSchedule{start: 50, end: 50},
+		mix:      mix,
+		pool:     &taskPool{},
+		recorder: newRecorder(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.run(ctx)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("loadgen did not stop after cancel")
+	}
+}
+
+func TestLoadgenCommand(t *testing.T) {
+	srv, _ := countingServer()
+	defer srv.Close()
+
+	code, out, _ := runCLI("loadgen", "-url", srv.URL, "-rate", "50", "-duration", "200ms", "-mix", "list=1", "-o", "json")
+	assert.Equal(t, exitOK, code)
+	var summary struct {
+		Requests   int64                `json:"requests"`
+		Operations map[string]*OpReport `json:"operations"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(out), &summary))
+	assert.Greater(t, summary.Requests, int64(0))
+	assert.Equal(t, summary.Requests, summary.Operations[opList].Requests)
+
+	code, _, _ = runCLI("loadgen", "-url", srv.URL, "-mix", "fly=1")
+	assert.Equal(t, exitUsage, code)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
+)
+
+// Metrics is where the CLI and its task client send metrics, names use the DogStatsD dotted
+// style and tags are key:value
+type Metrics interface {
+	Count(name string, value int64, tags []string)
+	Gauge(name string, value float64, tags []string)
+	Histogram(name string, value float64, tags []string)
+	Close() error
+}
+
+// metricsOptions configures the sinks newMetrics builds
+type metricsOptions struct {
+	StatsdAddr     string    // DogStatsD agent, empty uses DD_AGENT_HOST
+	PrometheusAddr string    // where the prometheus sink serves /metrics
+	Tags           []string  // sent with every metric
+	Stdout         io.Writer // where the stdout sink writes
+}
+
+// defaultSinks is statsd when a DogStatsD agent is configured and none otherwise
+func defaultSinks(getenv func(string) string) string {
+	if sinks := getenv(envPrefix + "METRICS"); sinks != "" {
+		return sinks
+	}
+	if getenv("DD_AGENT_HOST") != "" {
+		return "statsd"
+	}
+	return "none"
+}
+
+// parseTags splits a comma separated list of key:value tags
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// newMetrics builds the comma separated sinks: statsd, prometheus, stdout or none. Several sinks
+// all get every metric.
+func newMetrics(sinks string, opts metricsOptions) (Metrics, error) {
+	var all multiMetrics
+	for _, sink := range strings.Split(sinks, ",") {
+		var m Metrics
+		switch strings.TrimSpace(sink) {
+		case "statsd":
+			c, err := statsd.New(opts.StatsdAddr)
+			if err != nil {
+				all.Close()
+				return nil, fmt.Errorf("statsd metrics: %w", err)
+			}
+			m = &StatsdMetrics{client: c}
+		case "prometheus":
+			p, err := servePrometheus(opts.PrometheusAddr)
+			if err != nil {
+				all.Close()
+				return nil, fmt.Errorf("prometheus metrics: %w", err)
+			}
+			m = p
+		case "stdout":
+			m = &StdoutMetrics{out: opts.Stdout}
+		case "none", "":
+			continue
+		default:
+			all.Close()
+			return nil, fmt.Errorf("unknown metrics sink %q, use statsd, prometheus, stdout or none", sink)
+		}
+		all = append(all, m)
+	}
+	var m Metrics = noopMetrics{}
+	switch len(all) {
+	case 0:
+	case 1:
+		m = all[0]
+	default:
+		m = all
+	}
+	if len(opts.Tags) > 0 {
+		m = &taggedMetrics{Metrics: m, tags: opts.Tags}
+	}
+	return m, nil
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Count(string, int64, []string)       {}
+func (noopMetrics) Gauge(string, float64, []string)     {}
+func (noopMetrics) Histogram(string, float64, []string) {}
+func (noopMetrics) Close() error                        { return nil }
+
+// multiMetrics sends every metric to each of its sinks
+type multiMetrics []Metrics
+
+func (m multiMetrics) Count(name string, value int64, tags []string) {
+	for _, sink := range m {
+		sink.Count(name, value, tags)
+	}
+}
+
+func (m multiMetrics) Gauge(name string, value float64, tags []string) {
+	for _, sink := range m {
+		sink.Gauge(name, value, tags)
+	}
+}
+
+func (m multiMetrics) Histogram(name string, value float64, tags []string) {
+	for _, sink := range m {
+		sink.Histogram(name, value, tags)
+	}
+}
+
+func (m multiMetrics) Close() error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// taggedMetrics puts the global tags in front of the tags of every metric
+type taggedMetrics struct {
+	Metrics
+	tags []string
+}
+
+func (m *taggedMetrics) with(tags []string) []string {
+	return append(append([]string{}, m.tags...), tags...)
+}
+
+func (m *taggedMetrics) Count(name string, value int64, tags []string) {
+	m.Metrics.Count(name, value, m.with(tags))
+}
+
+func (m *taggedMetrics) Gauge(name string, value float64, tags []string) {
+	m.Metrics.Gauge(name, value, m.with(tags))
+}
+
+func (m *taggedMetrics) Histogram(name string, value float64, tags []string) {
+	m.Metrics.Histogram(name, value, m.with(tags))
+}
+
+// StatsdMetrics sends metrics to a DogStatsD agent
+type StatsdMetrics struct {
+	client *statsd.Client
+}
+
+func (m *StatsdMetrics) Count(name string, value int64, tags []string) {
+	m.client.Count(name, value, tags, 1)
+}
+
+func (m *StatsdMetrics) Gauge(name string, value float64, tags []string) {
+	m.client.Gauge(name, value, tags, 1)
+}
+
+func (m *StatsdMetrics) Histogram(name string, value float64, tags []string) {
+	m.client.Histogram(name, value, tags, 1)
+}
+
+// Close flushes buffered metrics before closing the connection
+func (m *StatsdMetrics) Close() error {
+	m.client.Flush()
+	return m.client.Close()
+}
+
+// StdoutMetrics writes each metric as a DogStatsD line, like name:1|c|#tag:value
+type StdoutMetrics struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (m *StdoutMetrics) write(name string, value string, kind string, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	line := name + ":" + value + "|" + kind
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	fmt.Fprintln(m.out, line)
+}
+
+func (m *StdoutMetrics) Count(name string, value int64, tags []string) {
+	m.write(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+func (m *StdoutMetrics) Gauge(name string, value float64, tags []string) {
+	m.write(name, strconv.FormatFloat(value, 'g', -1, 64), "g", tags)
+}
+
+func (m *StdoutMetrics) Histogram(name string, value float64, tags []string) {
+	m.write(name, strconv.FormatFloat(value, 'g', -1, 64), "h", tags)
+}
+
+func (m *StdoutMetrics) Close() error {
+	return nil
+}
+
+// PrometheusMetrics serves the metrics on /metrics in the Prometheus text format until closed
+type PrometheusMetrics struct {
+	*telemetry.PrometheusMetrics
+	server *http.Server
+}
+
+// servePrometheus starts serving the metrics on addr under /metrics
+func servePrometheus(addr string) (*PrometheusMetrics, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &PrometheusMetrics{PrometheusMetrics: telemetry.NewPrometheusMetrics()}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	m.server = &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go m.server.Serve(listener)
+	return m, nil
+}
+
+// Close stops serving /metrics, giving a scrape in progress a second to finish
+func (m *PrometheusMetrics) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return m.server.Shutdown(ctx)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMetricsSinks(t *testing.T) {
+	var out bytes.Buffer
+	m, err := newMetrics("stdout", metricsOptions{Tags: []string{"environment:ci"}, Stdout: &out})
+	assert.Nil(t, err)
+	m.Count("taskclient.requests.count", 1, []string{"operation:add_task", "status:201"})
+	m.Histogram("taskclient.request.duration_seconds", 0.25, nil)
+	m.Gauge("taskclient.breaker.state.gauge", 0, []string{"state:closed"})
+	assert.Nil(t, m.Close())
+	assert.Equal(t, "taskclient.requests.count:1|c|#environment:ci,operation:add_task,status:201\n"+
+		"taskclient.request.duration_seconds:0.25|h|#environment:ci\n"+
+		"taskclient.breaker.state.gauge:0|g|#environment:ci,state:closed\n", out.String())
+
+	m, err = newMetrics("none", metricsOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, noopMetrics{}, m)
+
+	_, err = newMetrics("stdout,graphite", metricsOptions{Stdout: &out})
+	assert.ErrorContains(t, err, `unknown metrics sink "graphite"`)
+}
+
+func TestPrometheusSinkServesMetrics(t *testing.T) {
+	var out bytes.Buffer
+	m, err := newMetrics("prometheus,stdout", metricsOptions{PrometheusAddr: "127.0.0.1:0", Stdout: &out})
+	assert.Nil(t, err)
+	defer m.Close()
+	m.Count("loadgen.operations.count", 2, []string{"operation:add", "result:ok"})
+	m.Histogram("loadgen.operation.duration_seconds", 0.02, []string{"operation:add", "result:ok"})
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+
+	prom := m.(multiMetrics)[0].(*PrometheusMetrics)
+	resp, err := http.Get("http://" + prom.server.Addr + "/metrics")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `loadgen_operations_total{operation="add",result="ok"} 2`)
+	assert.Contains(t, string(body), `loadgen_operation_duration_seconds_bucket{operation="add",result="ok",le="0.025"} 1`)
+	assert.Contains(t, string(body), `loadgen_operation_duration_seconds_count{operation="add",result="ok"} 1`)
+}
+
+func TestDefaultSinks(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(name string) string { return env[name] }
+	assert.Equal(t, "none", defaultSinks(getenv))
+	env["DD_AGENT_HOST"] = "10.0.0.1"
+	assert.Equal(t, "statsd", defaultSinks(getenv))
+	env[envPrefix+"METRICS"] = "prometheus"
+	assert.Equal(t, "prometheus", defaultSinks(getenv))
+}
+
+func TestCLIMetricsFlags(t *testing.T) {
+	srv := fakeServer()
+	defer srv.Close()
+
+	code, out, _ := runCLI("list", "-url", srv.URL, "-o", "plain", "-metrics", "stdout", "-metric-tags", "environment:ci,team:tasks")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "taskclient.requests.count:1|c|#environment:ci,team:tasks,operation:list_tasks,status:200\n")
+
+	code, _, errOut := runCLI("list", "-url", srv.URL, "-metrics", "carrier-pigeon")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, errOut, `unknown metrics sink "carrier-pigeon"`)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// writeTasks prints tasks as an aligned table, a JSON array or one tab separated line per task
+func (c *cli) writeTasks(tasks []taskclient.Task) error {
+	switch c.format {
+	case "json":
+		return c.writeJSON(tasks)
+	case "plain":
+		for _, task := range tasks {
+			fmt.Fprintf(c.out, "%d\t%t\t%s\n", task.Id, task.Completed, task.Title)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tDONE\tOWNER\tASSIGNEES")
+	for _, task := range tasks {
+		done := ""
+		if task.Completed {
+			done = "yes"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", task.Id, task.Title, done, task.Owner, strings.Join(task.Assignees, ","))
+	}
+	return w.Flush()
+}
+
+// writeTask prints a single task, in plain format one field per line
+func (c *cli) writeTask(task taskclient.Task) error {
+	switch c.format {
+	case "json":
+		return c.writeJSON(task)
+	case "plain":
+		fmt.Fprintf(c.out, "Id = %d\nTitle = %s\nDescription = %s\nCompleted = %t\n", task.Id, task.Title, task.Description, task.Completed)
+		if task.Owner != "" {
+			fmt.Fprintf(c.out, "Owner = %s\n", task.Owner)
+		}
+		if len(task.Assignees) > 0 {
+			fmt.Fprintf(c.out, "Assignees = %s\n", strings.Join(task.Assignees, ","))
+		}
+		return nil
+	}
+	return c.writeTasks([]taskclient.Task{task})
+}
+
+// writeResult prints the outcome of a command that returns no task, v is what JSON output gets
+func (c *cli) writeResult(msg string, v interface{}) error {
+	if c.format == "json" {
+		return c.writeJSON(v)
+	}
+	_, err := fmt.Fprintln(c.out, msg)
+	return err
+}
+
+func (c *cli) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
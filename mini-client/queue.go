@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// operations the queue can hold
+const (
+	queueAdd      = "add"
+	queueComplete = "complete"
+	queueEdit     = "edit"
+)
+
+// target is the server and list a queued operation was meant for, it is replayed only there.
+// The API key is not kept on disk, replays use the one in effect when sync runs.
+type target struct {
+	URL    string `json:"url"`
+	Tenant string `json:"tenant,omitempty"`
+	List   string `json:"list,omitempty"`
+}
+
+func (t target) String() string {
+	s := t.URL
+	if t.Tenant != "" {
+		s += " tenant " + t.Tenant
+	}
+	if t.List != "" {
+		s += " list " + t.List
+	}
+	return s
+}
+
+// queuedOp is an add, complete or edit made while task-manager was unreachable
+type queuedOp struct {
+	Seq            int64                `json:"seq"`
+	Op             string               `json:"op"`
+	Task           *taskclient.Task     `json:"task,omitempty"` // add, an Id of 0 gets the next free ID when replayed
+	ID             int64                `json:"id,omitempty"`   // complete
+	Edit           *taskclient.TaskEdit `json:"edit,omitempty"`
+	Base           *taskclient.Task     `json:"base,omitempty"` // edit, the task as last known when queued
+	IdempotencyKey string               `json:"idempotency_key"`
+	Target         target               `json:"target"`
+	QueuedAt       time.Time            `json:"queued_at"`
+	Attempts       int                  `json:"attempts"`
+	LastError      string               `json:"last_error,omitempty"`
+}
+
+func (op *queuedOp) taskID() int64 {
+	switch {
+	case op.Task != nil:
+		return op.Task.Id
+	case op.Edit != nil:
+		return op.Edit.Id
+	}
+	return op.ID
+}
+
+// describe says what op does, like "complete task 3", adds without an id are named by title
+func (op *queuedOp) describe() string {
+	if op.Task != nil && op.Task.Id == 0 {
+		return op.Op + " task " + fmt.Sprintf("%q", op.Task.Title)
+	}
+	return fmt.Sprintf("%s task %d", op.Op, op.taskID())
+}
+
+// conflict is a queued operation the server refused when it was replayed
+type conflict struct {
+	Op    *queuedOp `json:"op"`
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// journalRecord is a line of the journal: an operation being queued, retried, applied or refused
+type journalRecord struct {
+	Type  string    `json:"type"` // queued, failed, done or conflict
+	Seq   int64     `json:"seq"`
+	Op    *queuedOp `json:"op,omitempty"`
+	Error string    `json:"error,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// journal is the append-only file queued operations are kept in. Reading it back replays the
+// records, so a crash between two writes loses at most the line being written. Next to it, under
+// the same lock, are the tasks last read from each server, the base queued edits are checked against.
+type journal struct {
+	path      string
+	lock      *os.File
+	pending   []*queuedOp
+	conflicts []conflict
+	lastSeq   int64
+	seen      map[string]taskclient.Task
+}
+
+// queuePath is $TASK_MANAGER_QUEUE or queue.jsonl next to the config file
+func queuePath(getenv func(string) string) string {
+	if path := getenv(envPrefix + "QUEUE"); path != "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath(getenv)), "queue.jsonl")
+}
+
+// openJournal reads the journal at path, a missing file is an empty queue. The journal stays
+// locked until Close, so two tasks processes never queue or replay at the same time.
+func openJournal(path string) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("locking queue %s: %w", path, err)
+	}
+	j := &journal{path: path, lock: lock, seen: make(map[string]taskclient.Task)}
+	if data, err := os.ReadFile(path + ".seen"); err == nil {
+		// a damaged cache only costs conflict detection on the edits queued next
+		json.Unmarshal(data, &j.seen)
+	}
+	if err := j.read(); err != nil {
+		j.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Close releases the lock on the journal
+func (j *journal) Close() error {
+	return j.lock.Close()
+}
+
+func (j *journal) read() error {
+	path := j.path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if i == len(lines)-1 {
+				// the last write was cut short, it never happened
+				break
+			}
+			return fmt.Errorf("reading queue %s line %d: %w", path, i+1, err)
+		}
+		j.apply(rec)
+	}
+	return nil
+}
+
+// apply updates the queue with one journal record
+func (j *journal) apply(rec journalRecord) {
+	if rec.Seq > j.lastSeq {
+		j.lastSeq = rec.Seq
+	}
+	if rec.Type == "queued" && rec.Op != nil {
+		j.pending = append(j.pending, rec.Op)
+		return
+	}
+	for i, op := range j.pending {
+		if op.Seq != rec.Seq {
+			continue
+		}
+		switch rec.Type {
+		case "failed":
+			op.Attempts++
+			op.LastError = rec.Error
+		case "done":
+			j.pending = append(j.pending[:i], j.pending[i+1:]...)
+		case "conflict":
+			j.pending = append(j.pending[:i], j.pending[i+1:]...)
+			j.conflicts = append(j.conflicts, conflict{Op: op, Error: rec.Error, At: rec.At})
+		}
+		return
+	}
+}
+
+// write appends rec to the file and applies it
+func (j *journal) write(rec journalRecord) error {
+	rec.At = time.Now().UTC()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing queue %s: %w", j.path, err)
+	}
+	j.apply(rec)
+	return nil
+}
+
+func (j *journal) enqueue(op *queuedOp) error {
+	if op.Op == queueEdit && op.Base == nil {
+		op.Base = j.base(op.Target, op.Edit.Id)
+	}
+	op.Seq = j.lastSeq + 1
+	op.QueuedAt = time.Now().UTC()
+	return j.write(journalRecord{Type: "queued", Seq: op.Seq, Op: op})
+}
+
+func seenKey(t target, id int64) string {
+	return fmt.Sprintf("%s#%d", t, id)
+}
+
+// remember records tasks as just read from t, all says they are every task on t so the ones
+// missing were deleted. Failing to write the cache is not an error, it only serves conflict detection.
+func (j *journal) remember(t target, all bool, tasks ...taskclient.Task) {
+	if all {
+		for key := range j.seen {
+			if strings.HasPrefix(key, t.String()+"#") {
+				delete(j.seen, key)
+			}
+		}
+	}
+	for _, task := range tasks {
+		j.seen[seenKey(t, task.Id)] = task
+	}
+	data, err := json.Marshal(j.seen)
+	if err != nil {
+		return
+	}
+	if os.WriteFile(j.path+".seen.tmp", data, 0o600) == nil {
+		os.Rename(j.path+".seen.tmp", j.path+".seen")
+	}
+}
+
+// base is task id on t as this client knows it: as last read from the server with the operations
+// still queued for it applied. It is nil when the client never saw the task.
+func (j *journal) base(t target, id int64) *taskclient.Task {
+	var base *taskclient.Task
+	if seen, ok := j.seen[seenKey(t, id)]; ok {
+		base = &seen
+	}
+	for _, op := range j.pendingFor(t) {
+		if op.taskID() != id {
+			continue
+		}
+		switch {
+		case op.Op == queueAdd:
+			task := *op.Task
+			base = &task
+		case base == nil:
+		case op.Op == queueComplete:
+			base.Completed = true
+		case op.Op == queueEdit:
+			applyEdit(base, *op.Edit)
+		}
+	}
+	return base
+}
+
+func applyEdit(task *taskclient.Task, edit taskclient.TaskEdit) {
+	if edit.Title != nil {
+		task.Title = *edit.Title
+	}
+	if edit.Description != nil {
+		task.Description = *edit.Description
+	}
+	if edit.Assignees != nil {
+		task.Assignees = *edit.Assignees
+	}
+	if edit.Completed != nil {
+		task.Completed = *edit.Completed
+	}
+}
+
+// sameFields compares what an edit can change
+func sameFields(a, b taskclient.Task) bool {
+	if a.Title != b.Title || a.Description != b.Description || a.Completed != b.Completed || len(a.Assignees) != len(b.Assignees) {
+		return false
+	}
+	for i := range a.Assignees {
+		if a.Assignees[i] != b.Assignees[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingFor returns the operations waiting to be replayed on t, oldest first
+func (j *journal) pendingFor(t target) []*queuedOp {
+	var ops []*queuedOp
+	for _, op := range j.pending {
+		if op.Target == t {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// compact rewrites the journal with only what is still pending or conflicting, removing it
+// once nothing is left. forgetConflicts drops the conflicts too.
+func (j *journal) compact(forgetConflicts bool) error {
+	if forgetConflicts {
+		j.conflicts = nil
+	}
+	if len(j.pending) == 0 && len(j.conflicts) == 0 {
+		err := os.Remove(j.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range j.conflicts {
+		enc.Encode(journalRecord{Type: "queued", Seq: c.Op.Seq, Op: c.Op, At: c.Op.QueuedAt})
+		enc.Encode(journalRecord{Type: "conflict", Seq: c.Op.Seq, Error: c.Error, At: c.At})
+	}
+	for _, op := range j.pending {
+		enc.Encode(journalRecord{Type: "queued", Seq: op.Seq, Op: op, At: op.QueuedAt})
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// unreachable is true when a call got no answer from task-manager at all, so it is worth
+// queueing rather than reporting: the circuit is open, the connection could not be made or was
+// hung up on before an answer, or the call timed out. TLS failures, bad URLs and a canceled
+// call are reported, trying again later would not fix them.
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case err == nil || errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, taskclient.ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return true
+	case errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET):
+		return true
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// refused is true when the server turned a replayed operation down for good: the task is gone,
+// in another state or the request is no longer valid. Auth errors are not, fixing the key and
+// syncing again can still apply it.
+func refused(err error) bool {
+	var apiErr *taskclient.APIError
+	return errors.Is(err, taskclient.ErrConflict) || errors.Is(err, taskclient.ErrNotFound) ||
+		errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest
+}
+
+// applyOp sends op to the server with its idempotency key. The server forgets keys after ten
+// minutes, so when replaying an add whose ID is already taken counts as applied if the task is
+// the one queued and as a conflict otherwise, and a complete of a task already completed counts
+// as applied. A replayed edit is refused when the task changed on the server since its base,
+// unless the change is the edit itself.
+func applyOp(ctx context.Context, tasks *taskclient.Client, op *queuedOp, replay bool) (taskclient.Task, error) {
+	ctx = taskclient.WithIdempotencyKey(ctx, op.IdempotencyKey)
+	switch op.Op {
+	case queueAdd:
+		task := *op.Task
+		if task.Id == 0 {
+			existing, err := tasks.ListTasks(ctx, true)
+			if err != nil {
+				return taskclient.Task{}, err
+			}
+			for _, t := range existing {
+				if t.Id > task.Id {
+					task.Id = t.Id
+				}
+			}
+			task.Id++
+		} else if replay {
+			found, err := tasks.GetTask(ctx, task.Id)
+			if err == nil {
+				if found.Title == task.Title && found.Description == task.Description {
+					return found, nil
+				}
+				return taskclient.Task{}, fmt.Errorf("%w: task %d already exists as %q", taskclient.ErrConflict, task.Id, found.Title)
+			}
+			if !errors.Is(err, taskclient.ErrNotFound) {
+				return taskclient.Task{}, err
+			}
+		}
+		return tasks.AddTask(ctx, task)
+	case queueComplete:
+		task, err := tasks.CompleteTask(ctx, op.ID)
+		if replay && errors.Is(err, taskclient.ErrConflict) {
+			if found, getErr := tasks.GetTask(ctx, op.ID); getErr == nil && found.Completed {
+				return found, nil
+			}
+		}
+		return task, err
+	case queueEdit:
+		if replay && op.Base != nil {
+			current, err := tasks.GetTask(ctx, op.Edit.Id)
+			if err != nil {
+				return taskclient.Task{}, err
+			}
+			if !sameFields(current, *op.Base) {
+				edited := *op.Base
+				applyEdit(&edited, *op.Edit)
+				if sameFields(current, edited) {
+					return current, nil
+				}
+				return taskclient.Task{}, fmt.Errorf("%w: task %d changed on the server since the edit was queued", taskclient.ErrConflict, op.Edit.Id)
+			}
+		}
+		return tasks.EditTask(ctx, *op.Edit)
+	}
+	return taskclient.Task{}, fmt.Errorf("unknown queued operation %q", op.Op)
+}
+
+// syncResult is what replaying the queue did
+type syncResult struct {
+	Synced    int        `json:"synced"`
+	Conflicts []conflict `json:"conflicts,omitempty"`
+	Pending   int        `json:"pending"`
+}
+
+// syncConflictError is returned when queued operations were refused, it exits with exitConflict
+type syncConflictError struct {
+	n int
+}
+
+func (e *syncConflictError) Error() string {
+	return fmt.Sprintf("%d queued operations conflicted, see tasks sync -status", e.n)
+}
+
+func (e *syncConflictError) Unwrap() error { return taskclient.ErrConflict }
+
+// replay sends the operations pending for t in order. It stops at the first one that fails
+// without being refused, leaving it and the rest queued, and returns that error. Refused
+// operations are recorded as conflicts and the replay goes on.
+func (j *journal) replay(ctx context.Context, tasks *taskclient.Client, t target) (syncResult, error) {
+	var result syncResult
+	ops := j.pendingFor(t)
+	for i, op := range ops {
+		task, err := applyOp(ctx, tasks, op, true)
+		switch {
+		case err == nil:
+			j.remember(t, false, task)
+			err = j.write(journalRecord{Type: "done", Seq: op.Seq})
+			result.Synced++
+		case refused(err):
+			c := conflict{Op: op, Error: err.Error(), At: time.Now().UTC()}
+			if err = j.write(journalRecord{Type: "conflict", Seq: op.Seq, Error: c.Error}); err == nil {
+				result.Conflicts = append(result.Conflicts, c)
+			}
+		default:
+			if writeErr := j.write(journalRecord{Type: "failed", Seq: op.Seq, Error: err.Error()}); writeErr != nil {
+				err = writeErr
+			}
+			result.Pending = len(ops) - i
+			return result, err
+		}
+		if err != nil {
+			result.Pending = len(ops) - i - 1
+			return result, err
+		}
+	}
+	return result, j.compact(false)
+}
+
+// runOrQueue applies op or, when task-manager cannot be reached, queues it. Operations already
+// queued for the same server are replayed first so everything lands in the order it was made.
+// queued is true when op went to the queue.
+func (o *options) runOrQueue(ctx context.Context, tasks *taskclient.Client, op *queuedOp) (task taskclient.Task, queued bool, err error) {
+	op.IdempotencyKey = taskclient.NewIdempotencyKey()
+	op.Target = o.target()
+	if !o.queue {
+		task, err = applyOp(ctx, tasks, op, false)
+		return task, false, err
+	}
+	j, err := openJournal(queuePath(os.Getenv))
+	if err != nil {
+		return task, false, err
+	}
+	defer j.Close()
+	var conflicts int
+	if len(j.pendingFor(op.Target)) > 0 {
+		result, err := j.replay(ctx, tasks, op.Target)
+		if unreachable(err) {
+			return task, true, j.enqueue(op)
+		}
+		if err != nil {
+			return task, false, err
+		}
+		conflicts = len(result.Conflicts)
+	}
+	task, err = applyOp(ctx, tasks, op, false)
+	if unreachable(err) {
+		return task, true, j.enqueue(op)
+	}
+	if err == nil {
+		j.remember(op.Target, false, task)
+	}
+	if err == nil && conflicts > 0 {
+		err = &syncConflictError{conflicts}
+	}
+	return task, false, err
+}
+
+func (o *options) target() target {
+	return target{URL: o.URL, Tenant: o.Tenant, List: o.List}
+}
+
+// remember keeps tasks just read as the base of the edits queued later, all says they are every
+// task on the server. It is best effort, reads do not fail over the cache.
+func (o *options) remember(all bool, tasks ...taskclient.Task) {
+	j, err := openJournal(queuePath(os.Getenv))
+	if err != nil {
+		return
+	}
+	defer j.Close()
+	j.remember(o.target(), all, tasks...)
+}
+
+// writeApplied prints the task op produced, or that op was queued
+func (c *cli) writeApplied(op *queuedOp, task taskclient.Task, queued bool) error {
+	if !queued {
+		return c.writeTask(task)
+	}
+	return c.writeResult(fmt.Sprintf("task-manager is unreachable, queued %s, run tasks sync once it is back", op.describe()),
+		map[string]interface{}{"queued": true, "seq": op.Seq, "op": op})
+}
+
+// syncCommand replays the queued operations for the server in use, or shows the queue
+func syncCommand(fs *flag.FlagSet, opts *options) runFunc {
+	status := fs.Bool("status", false, "show the pending and conflicting operations instead of replaying them")
+	forget := fs.Bool("forget-conflicts", false, "drop the operations the server refused from the queue")
+	return func(ctx context.Context, cli *cli, args []string) error {
+		if len(args) != 0 {
+			return usageErrorf("sync takes no arguments")
+		}
+		j, err := openJournal(queuePath(os.Getenv))
+		if err != nil {
+			return err
+		}
+		defer j.Close()
+		if *status {
+			return cli.writeQueue(j)
+		}
+		if *forget {
+			n := len(j.conflicts)
+			if err := j.compact(true); err != nil {
+				return err
+			}
+			return cli.writeResult(fmt.Sprintf("Forgot %d conflicting operations", n), map[string]int{"forgotten": n})
+		}
+		tasks, err := opts.client()
+		if err != nil {
+			return err
+		}
+		result, err := j.replay(ctx, tasks, opts.target())
+		if writeErr := cli.writeSync(result); writeErr != nil {
+			return writeErr
+		}
+		if err != nil {
+			return err
+		}
+		if len(result.Conflicts) > 0 {
+			return &syncConflictError{len(result.Conflicts)}
+		}
+		return nil
+	}
+}
+
+func (c *cli) writeSync(r syncResult) error {
+	if c.format == "json" {
+		return c.writeJSON(r)
+	}
+	for _, conflict := range r.Conflicts {
+		fmt.Fprintf(c.out, "conflict: %s: %s\n", conflict.Op.describe(), conflict.Error)
+	}
+	_, err := fmt.Fprintf(c.out, "Synced %d operations, %d conflicts, %d still pending\n", r.Synced, len(r.Conflicts), r.Pending)
+	return err
+}
+
+// writeQueue prints the pending operations, oldest first, then the conflicting ones
+func (c *cli) writeQueue(j *journal) error {
+	if c.format == "json" {
+		pending := j.pending
+		if pending == nil {
+			pending = []*queuedOp{}
+		}
+		return c.writeJSON(map[string]interface{}{"pending": pending, "conflicts": j.conflicts})
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	if c.format == "table" {
+		fmt.Fprintln(w, "SEQ\tSTATE\tOP\tTASK\tTARGET\tQUEUED\tATTEMPTS\tERROR")
+	}
+	row := func(op *queuedOp, state string, msg string) {
+		task := fmt.Sprint(op.taskID())
+		if op.Task != nil && op.Task.Id == 0 {
+			task = "new"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", op.Seq, state, op.Op, task, op.Target,
+			op.QueuedAt.Local().Format(time.RFC3339), op.Attempts, strings.ReplaceAll(msg, "\n", " "))
+	}
+	for _, op := range j.pending {
+		row(op, "pending", op.LastError)
+	}
+	for _, conflict := range j.conflicts {
+		row(conflict.Op, "conflict", conflict.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if c.format == "table" {
+		_, err := fmt.Fprintf(c.out, "%d pending, %d conflicts\n", len(j.pending), len(j.conflicts))
+		return err
+	}
+	return nil
+}
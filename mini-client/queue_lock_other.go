@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile does nothing where flock is not available, tasks processes sharing a queue there
+// must not run at the same time
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+func TestJournalSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	j, err := openJournal(path)
+	assert.Nil(t, err)
+	here := target{URL: "http://a"}
+	for _, op := range []*queuedOp{
+		{Op: queueAdd, Task: &taskclient.Task{Id: 1, Title: "one"}, Target: here},
+		{Op: queueComplete, ID: 1, Target: here},
+		{Op: queueComplete, ID: 2, Target: target{URL: "http://b"}},
+	} {
+		assert.Nil(t, j.enqueue(op))
+	}
+	assert.Nil(t, j.write(journalRecord{Type: "failed", Seq: 1, Error: "connection refused"}))
+	assert.Nil(t, j.write(journalRecord{Type: "done", Seq: 1}))
+	assert.Nil(t, j.write(journalRecord{Type: "conflict", Seq: 2, Error: "already completed"}))
+
+	// a write cut short by a crash is ignored
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err)
+	f.WriteString(`{"type":"done","se`)
+	f.Close()
+
+	assert.Nil(t, j.Close())
+	j, err = openJournal(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), j.lastSeq)
+	if assert.Len(t, j.pending, 1) {
+		assert.Equal(t, int64(3), j.pending[0].Seq)
+	}
+	if assert.Len(t, j.conflicts, 1) {
+		assert.Equal(t, "already completed", j.conflicts[0].Error)
+		assert.Equal(t, 1, j.conflicts[0].Op.Attempts+1)
+	}
+	assert.Empty(t, j.pendingFor(here))
+
+	assert.Nil(t, j.compact(false))
+	assert.Nil(t, j.Close())
+	j, err = openJournal(path)
+	assert.Nil(t, err)
+	assert.Len(t, j.pending, 1)
+	assert.Len(t, j.conflicts, 1)
+	assert.Nil(t, j.compact(true))
+	j.pending = nil
+	assert.Nil(t, j.compact(false))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, j.Close())
+}
+
+func TestJournalIsLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	first, err := openJournal(path)
+	assert.Nil(t, err)
+	opened := make(chan *journal)
+	go func() {
+		second, err := openJournal(path)
+		assert.Nil(t, err)
+		opened <- second
+	}()
+	select {
+	case <-opened:
+		t.Fatal("the journal was opened twice at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Nil(t, first.enqueue(&queuedOp{Op: queueComplete, ID: 1}))
+	assert.Nil(t, first.Close())
+	second := <-opened
+	assert.Len(t, second.pending, 1)
+	assert.Nil(t, second.Close())
+}
+
+func TestUnreachable(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://a", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	cases := []struct {
+		err         error
+		unreachable bool
+	}{
+		{nil, false},
+		{refused, true},
+		{fmt.Errorf("%w: %v", taskclient.ErrCircuitOpen, refused), true},
+		{&url.Error{Op: "Post", URL: "http://a", Err: io.EOF}, true},
+		{&url.Error{Op: "Post", URL: "http://a", Err: context.DeadlineExceeded}, true},
+		{&url.Error{Op: "Post", URL: "http://a", Err: context.Canceled}, false},
+		{&url.Error{Op: "Post", URL: "https://a", Err: x509.UnknownAuthorityError{}}, false},
+		{&url.Error{Op: "Post", URL: "ftp://a", Err: errors.New("unsupported protocol scheme")}, false},
+		{taskclient.ErrConflict, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.unreachable, unreachable(c.err), "%v", c.err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "complete task 0", (&queuedOp{Op: queueComplete}).describe())
+	assert.Equal(t, "edit task 0", (&queuedOp{Op: queueEdit, Edit: &taskclient.TaskEdit{}}).describe())
+	assert.Equal(t, "add task 4", (&queuedOp{Op: queueAdd, Task: &taskclient.Task{Id: 4}}).describe())
+	assert.Equal(t, `add task "no id"`, (&queuedOp{Op: queueAdd, Task: &taskclient.Task{Title: "no id"}}).describe())
+
+	t.Setenv(envPrefix+"QUEUE", filepath.Join(t.TempDir(), "queue.jsonl"))
+	code, out, _ := runCLI("complete", "-url", "http://127.0.0.1:1", "-retries", "1", "-o", "plain", "0")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "queued complete task 0")
+	code, out, _ = runCLI("sync", "-status", "-o", "plain")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "pending  complete  0  ")
+	code, out, _ = runCLI("sync", "-url", "http://127.0.0.1:1", "-retries", "1")
+	assert.Equal(t, exitUnavailable, code)
+	assert.Contains(t, out, "1 still pending")
+}
+
+// flakyServer serves tasks like taskServer while up, hangs up on every request while down and,
+// while dropping, handles requests but hangs up before answering
+type flakyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	state    string
+	requests []string // method, path and idempotency key
+}
+
+func newFlakyServer() *flakyServer {
+	tasks := taskServer()
+	f := &flakyServer{state: "up"}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		f.mu.Lock()
+		state := f.state
+		f.requests = append(f.requests, strings.TrimSpace(req.Method+" "+req.URL.Path+" "+req.Header.Get("Idempotency-Key")))
+		f.mu.Unlock()
+		if state == "up" {
+			tasks.Config.Handler.ServeHTTP(res, req)
+			return
+		}
+		if state == "dropping" {
+			tasks.Config.Handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		conn, _, _ := res.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	f.Config.RegisterOnShutdown(tasks.Close)
+	return f
+}
+
+// sent returns the requests made to path
+func (f *flakyServer) sent(path string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var requests []string
+	for _, r := range f.requests {
+		if strings.Contains(r, " "+path+" ") {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func (f *flakyServer) set(state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = state
+}
+
+func TestQueueWhileUnreachableAndSync(t *testing.T) {
+	t.Setenv(envPrefix+"QUEUE", filepath.Join(t.TempDir(), "queue.jsonl"))
+	srv := newFlakyServer()
+	defer srv.Close()
+	flags := []string{"-url", srv.URL, "-retries", "1", "-o", "plain"}
+
+	srv.set("down")
+	code, out, _ := runCLI(append([]string{"add", "-id", "5", "write", "report"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "task-manager is unreachable, queued add task 5, run tasks sync once it is back\n", out)
+	runCLI(append([]string{"complete", "5"}, flags...)...)
+	runCLI(append([]string{"edit", "5", "-assignees", "user:bob"}, flags...)...)
+	runCLI(append([]string{"complete", "9"}, flags...)...)
+	code, _, _ = runCLI(append([]string{"add", "no", "id"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+
+	code, out, _ = runCLI("sync", "-status", "-o", "json")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, `"op": "edit"`)
+	code, out, _ = runCLI("sync", "-status")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, 7, strings.Count(out, "\n"))
+	assert.Contains(t, out, "5 pending, 0 conflicts\n")
+
+	// still down, nothing is lost
+	code, out, _ = runCLI(append([]string{"sync"}, flags...)...)
+	assert.Equal(t, exitUnavailable, code)
+	assert.Equal(t, "Synced 0 operations, 0 conflicts, 5 still pending\n", out)
+
+	srv.set("up")
+	code, out, _ = runCLI(append([]string{"sync"}, flags...)...)
+	// every try at an operation carries the same idempotency key
+	// the add is tried when it is made and when it is replayed, with the same idempotency key;
+	// what came after it waited in the queue instead of overtaking it
+	if adds := srv.sent("/tasks/add"); assert.Len(t, adds, 3) {
+		assert.Equal(t, adds[0], adds[1])
+	}
+	assert.Len(t, srv.sent("/tasks/edit"), 1)
+	assert.Equal(t, exitConflict, code)
+	assert.Equal(t, "conflict: complete task 9: task-manager returned 404: No task with ID = 9\n"+
+		"Synced 4 operations, 1 conflicts, 0 still pending\n", out)
+
+	code, out, _ = runCLI(append([]string{"get", "5"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "Id = 5\nTitle = write report\nDescription = \nCompleted = true\nAssignees = user:bob\n", out)
+	code, out, _ = runCLI(append([]string{"get", "6"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "Title = no id\n")
+
+	code, out, _ = runCLI("sync", "-status", "-o", "plain")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "conflict  complete  9")
+	code, _, _ = runCLI("sync", "-forget-conflicts")
+	assert.Equal(t, exitOK, code)
+	_, err := os.Stat(os.Getenv(envPrefix + "QUEUE"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReplayDoesNotDuplicate(t *testing.T) {
+	t.Setenv(envPrefix+"QUEUE", filepath.Join(t.TempDir(), "queue.jsonl"))
+	srv := newFlakyServer()
+	defer srv.Close()
+	flags := []string{"-url", srv.URL, "-retries", "1", "-o", "plain"}
+
+	// the server adds the task but the answer never arrives
+	srv.set("dropping")
+	code, out, _ := runCLI(append([]string{"add", "-id", "3", "lost", "answer"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "queued add task 3")
+
+	// a later change replays the queue first, the add is found already applied
+	srv.set("up")
+	code, out, _ = runCLI(append([]string{"complete", "3"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "Completed = true")
+	code, out, _ = runCLI(append([]string{"list", "-show-completed"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, 1, strings.Count(out, "lost answer"))
+
+	assert.Len(t, srv.sent("/tasks/add"), 1)
+	j, err := openJournal(os.Getenv(envPrefix + "QUEUE"))
+	assert.Nil(t, err)
+	assert.Empty(t, j.pending)
+	assert.Nil(t, j.Close())
+}
+
+func TestReplayCompleteAfterKeyExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	t.Setenv(envPrefix+"QUEUE", path)
+	srv := newFlakyServer()
+	defer srv.Close()
+	flags := []string{"-url", srv.URL, "-retries", "1", "-o", "plain"}
+	code, _, _ := runCLI(append([]string{"add", "-id", "4", "done", "already"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	code, _, _ = runCLI(append([]string{"complete", "4"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+
+	// the complete was applied but its answer lost, and the server has forgotten its key since
+	j, err := openJournal(path)
+	assert.Nil(t, err)
+	assert.Nil(t, j.enqueue(&queuedOp{Op: queueComplete, ID: 4, IdempotencyKey: "expired", Target: target{URL: srv.URL}}))
+	assert.Nil(t, j.enqueue(&queuedOp{Op: queueComplete, ID: 8, IdempotencyKey: "missing", Target: target{URL: srv.URL}}))
+	assert.Nil(t, j.Close())
+
+	code, out, _ := runCLI(append([]string{"sync"}, flags...)...)
+	assert.Equal(t, exitConflict, code)
+	assert.Equal(t, "conflict: complete task 8: task-manager returned 404: No task with ID = 8\n"+
+		"Synced 1 operations, 1 conflicts, 0 still pending\n", out)
+}
+
+func TestReplayEditDetectsChangesMadeWhileQueued(t *testing.T) {
+	t.Setenv(envPrefix+"QUEUE", filepath.Join(t.TempDir(), "queue.jsonl"))
+	srv := newFlakyServer()
+	defer srv.Close()
+	flags := []string{"-url", srv.URL, "-retries", "1", "-o", "plain"}
+	code, _, _ := runCLI(append([]string{"add", "-id", "1", "mine"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	code, _, _ = runCLI(append([]string{"add", "-id", "2", "lost", "answer"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	code, _, _ = runCLI(append([]string{"list"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+
+	srv.set("down")
+	code, out, _ := runCLI(append([]string{"edit", "1", "-assignees", "user:me"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "queued edit task 1")
+	// the server applies this one but the answer never arrives
+	srv.set("dropping")
+	code, out, _ = runCLI(append([]string{"edit", "2", "-assignees", "user:me"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "queued edit task 2")
+
+	// someone else edits task 1 meanwhile, replaying would clobber their change
+	srv.set("up")
+	theirs := []string{"user:them"}
+	_, err := taskclient.New(srv.URL).EditTask(context.Background(), taskclient.TaskEdit{Id: 1, Assignees: &theirs})
+	assert.Nil(t, err)
+	code, out, _ = runCLI(append([]string{"sync"}, flags...)...)
+	assert.Equal(t, exitConflict, code)
+	assert.Equal(t, "conflict: edit task 1: conflict: task 1 changed on the server since the edit was queued\n"+
+		"Synced 1 operations, 1 conflicts, 0 still pending\n", out)
+	// the edit of task 1 was only tried while down, the one of task 2 was found applied
+	assert.Len(t, srv.sent("/tasks/edit"), 3)
+
+	code, out, _ = runCLI(append([]string{"get", "1"}, flags...)...)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "Assignees = user:them\n")
+}
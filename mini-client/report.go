@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// exitRegression is the exit code of report compare when the candidate regressed
+const exitRegression = 7
+
+// Report is the outcome of a load generator run. Latencies are in milliseconds.
+type Report struct {
+	Started    time.Time            `json:"started"`
+	Duration   float64              `json:"duration_seconds"`
+	Requests   int64                `json:"requests"`
+	Errors     int64                `json:"errors"`
+	Throughput float64              `json:"throughput"`
+	Dropped    int64                `json:"dropped"`
+	Operations map[string]*OpReport `json:"operations"`
+}
+
+// OpReport is the outcome of one operation, errors are counted by status code or failure kind
+type OpReport struct {
+	Requests       int64            `json:"requests"`
+	Errors         int64            `json:"errors"`
+	Throughput     float64          `json:"throughput"`
+	Mean           float64          `json:"mean_ms"`
+	P50            float64          `json:"p50_ms"`
+	P90            float64          `json:"p90_ms"`
+	P99            float64          `json:"p99_ms"`
+	Max            float64          `json:"max_ms"`
+	ErrorsByStatus map[string]int64 `json:"errors_by_status,omitempty"`
+}
+
+func (o *OpReport) errorRate() float64 {
+	if o.Requests == 0 {
+		return 0
+	}
+	return float64(o.Errors) / float64(o.Requests)
+}
+
+// errorKind names a failure in the error breakdown: the status code, or why no response came back
+func errorKind(err error) string {
+	var apiErr *taskclient.APIError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return strconv.Itoa(apiErr.StatusCode)
+	case errors.Is(err, taskclient.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, errAssertion):
+		return "assertion"
+	case errors.Is(err, errTemplate):
+		return "template"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "network"
+}
+
+// opRecorder collects the latencies and errors of one operation
+type opRecorder struct {
+	latencies histogram
+	errors    map[string]int64
+}
+
+// recorder collects what a load generator run does, it is safe for concurrent use
+type recorder struct {
+	mu      sync.Mutex
+	started time.Time
+	ops     map[string]*opRecorder
+	dropped int64
+}
+
+func newRecorder() *recorder {
+	return &recorder{started: time.Now(), ops: make(map[string]*opRecorder)}
+}
+
+func (r *recorder) record(op string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.ops[op]
+	if !ok {
+		rec = &opRecorder{errors: make(map[string]int64)}
+		r.ops[op] = rec
+	}
+	rec.latencies.record(d)
+	if err != nil {
+		rec.errors[errorKind(err)]++
+	}
+}
+
+func (r *recorder) drop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropped++
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// report summarises everything recorded, elapsed is how long the run took
+func (r *recorder) report(elapsed time.Duration) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &Report{Started: r.started, Duration: elapsed.Seconds(), Dropped: r.dropped, Operations: make(map[string]*OpReport)}
+	for op, rec := range r.ops {
+		h := &rec.latencies
+		o := &OpReport{
+			Requests: h.total,
+			Mean:     milliseconds(h.mean()),
+			P50:      milliseconds(h.percentile(50)),
+			P90:      milliseconds(h.percentile(90)),
+			P99:      milliseconds(h.percentile(99)),
+			Max:      milliseconds(time.Duration(h.max) * time.Microsecond),
+		}
+		for kind, n := range rec.errors {
+			if o.ErrorsByStatus == nil {
+				o.ErrorsByStatus = make(map[string]int64)
+			}
+			o.ErrorsByStatus[kind] = n
+			o.Errors += n
+		}
+		if elapsed > 0 {
+			o.Throughput = float64(o.Requests) / elapsed.Seconds()
+		}
+		report.Operations[op] = o
+		if op == opScenario {
+			// an iteration is made of the step requests already counted, it only gets its own row
+			continue
+		}
+		report.Requests += o.Requests
+		report.Errors += o.Errors
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+	return report
+}
+
+func (r *Report) opNames() []string {
+	ops := make([]string, 0, len(r.Operations))
+	for op := range r.Operations {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func formatErrors(byStatus map[string]int64) string {
+	kinds := make([]string, 0, len(byStatus))
+	for kind := range byStatus {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%s=%d", kind, byStatus[kind])
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeReport prints a line per operation followed by the totals
+func (c *cli) writeReport(r *Report) error {
+	if c.format == "json" {
+		return c.writeJSON(r)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	if c.format == "table" {
+		fmt.Fprintln(w, "OP\tREQUESTS\tERRORS\tRPS\tMEAN\tP50\tP90\tP99\tMAX\tERRORS BY STATUS")
+	}
+	for _, op := range r.opNames() {
+		o := r.Operations[op]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t%.2fms\t%s\n",
+			op, o.Requests, o.Errors, o.Throughput, o.Mean, o.P50, o.P90, o.P99, o.Max, formatErrors(o.ErrorsByStatus))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(c.out, "%d requests, %d errors in %.1fs (%.1f/s), %d dropped\n", r.Requests, r.Errors, r.Duration, r.Throughput, r.Dropped)
+	return err
+}
+
+// writeFile saves the report as CSV if path ends in .csv and as JSON otherwise
+func (r *Report) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = r.writeCSV(f)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *Report) writeCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"op", "requests", "errors", "throughput", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "max_ms", "errors_by_status"})
+	number := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	for _, op := range r.opNames() {
+		o := r.Operations[op]
+		w.Write([]string{op, strconv.FormatInt(o.Requests, 10), strconv.FormatInt(o.Errors, 10), number(o.Throughput),
+			number(o.Mean), number(o.P50), number(o.P90), number(o.P99), number(o.Max), formatErrors(o.ErrorsByStatus)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// readReport loads a report saved as JSON
+func readReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parsing report %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// comparison is one metric of one operation in two reports
+type comparison struct {
+	Op         string  `json:"op"`
+	Metric     string  `json:"metric"`
+	Base       float64 `json:"base"`
+	Candidate  float64 `json:"candidate"`
+	Regression bool    `json:"regression"`
+}
+
+// compareReports compares every operation both reports have. Latencies regress when they grow by
+// more than threshold (0.1 is 10%), throughput when it shrinks by more than threshold and the
+// error rate when it grows by more than errorRateThreshold (0.01 is one percentage point).
+func compareReports(base *Report, candidate *Report, threshold float64, errorRateThreshold float64) []comparison {
+	var comparisons []comparison
+	for _, op := range base.opNames() {
+		b := base.Operations[op]
+		c, ok := candidate.Operations[op]
+		if !ok {
+			continue
+		}
+		for _, m := range []struct {
+			name string
+			b, c float64
+		}{{"p50_ms", b.P50, c.P50}, {"p90_ms", b.P90, c.P90}, {"p99_ms", b.P99, c.P99}} {
+			comparisons = append(comparisons, comparison{op, m.name, m.b, m.c, m.c > m.b*(1+threshold)})
+		}
+		comparisons = append(comparisons,
+			comparison{op, "throughput", b.Throughput, c.Throughput, c.Throughput < b.Throughput*(1-threshold)},
+			comparison{op, "error_rate", b.errorRate(), c.errorRate(), c.errorRate() > b.errorRate()+errorRateThreshold})
+	}
+	return comparisons
+}
+
+func (c *cli) writeComparisons(comparisons []comparison) error {
+	if c.format == "json" {
+		return c.writeJSON(comparisons)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	if c.format == "table" {
+		fmt.Fprintln(w, "OP\tMETRIC\tBASE\tCANDIDATE\tCHANGE\t")
+	}
+	for _, cmp := range comparisons {
+		change := "n/a"
+		if cmp.Base != 0 {
+			change = fmt.Sprintf("%+.1f%%", (cmp.Candidate-cmp.Base)/cmp.Base*100)
+		}
+		flag := ""
+		if cmp.Regression {
+			flag = "REGRESSION"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.3f\t%.3f\t%s\t%s\n", cmp.Op, cmp.Metric, cmp.Base, cmp.Candidate, change, flag)
+	}
+	return w.Flush()
+}
+
+// regressionError is returned by report compare so it exits with exitRegression
+type regressionError struct {
+	n int
+}
+
+func (e *regressionError) Error() string {
+	return fmt.Sprintf("%d metrics regressed", e.n)
+}
+
+// reportCommand shows a saved report or compares two of them
+func reportCommand(fs *flag.FlagSet, opts *options) runFunc {
+	threshold := fs.Float64("threshold", 0.1, "relative change in latency or throughput that counts as a regression")
+	errorRateThreshold := fs.Float64("error-rate-threshold", 0.01, "increase of the error rate that counts as a regression")
+	return func(ctx context.Context, cli *cli, args []string) error {
+		if len(args) == 2 && args[0] == "show" {
+			r, err := readReport(args[1])
+			if err != nil {
+				return err
+			}
+			return cli.writeReport(r)
+		}
+		if len(args) != 3 || args[0] != "compare" {
+			return usageErrorf("expected show FILE or compare BASE CANDIDATE")
+		}
+		base, err := readReport(args[1])
+		if err != nil {
+			return err
+		}
+		candidate, err := readReport(args[2])
+		if err != nil {
+			return err
+		}
+		comparisons := compareReports(base, candidate, *threshold, *errorRateThreshold)
+		if err := cli.writeComparisons(comparisons); err != nil {
+			return err
+		}
+		regressions := 0
+		for _, cmp := range comparisons {
+			if cmp.Regression {
+				regressions++
+			}
+		}
+		if regressions > 0 {
+			return &regressionError{regressions}
+		}
+		return nil
+	}
+}
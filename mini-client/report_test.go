@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+func TestRecorderReport(t *testing.T) {
+	r := newRecorder()
+	for i := 1; i <= 100; i++ {
+		r.record(opList, time.Duration(i)*time.Millisecond, nil)
+	}
+	r.record(opAdd, time.Millisecond, &taskclient.APIError{StatusCode: http.StatusServiceUnavailable})
+	r.record(opAdd, time.Millisecond, &taskclient.APIError{StatusCode: http.StatusServiceUnavailable})
+	r.record(opAdd, time.Millisecond, fmt.Errorf("add: %w", taskclient.ErrCircuitOpen))
+	r.record(opAdd, time.Millisecond, errors.New("connection refused"))
+	r.drop()
+
+	report := r.report(2 * time.Second)
+	assert.Equal(t, int64(104), report.Requests)
+	assert.Equal(t, int64(4), report.Errors)
+	assert.Equal(t, int64(1), report.Dropped)
+	assert.Equal(t, 52.0, report.Throughput)
+
+	list := report.Operations[opList]
+	assert.Equal(t, 50.0, list.Throughput)
+	assert.InEpsilon(t, 50, list.P50, 0.02)
+	assert.InEpsilon(t, 90, list.P90, 0.02)
+	assert.InEpsilon(t, 99, list.P99, 0.02)
+	assert.Equal(t, 100.0, list.Max)
+	assert.Nil(t, list.ErrorsByStatus)
+
+	add := report.Operations[opAdd]
+	assert.Equal(t, map[string]int64{"503": 2, "circuit_open": 1, "network": 1}, add.ErrorsByStatus)
+	assert.Equal(t, "503=2 circuit_open=1 network=1", formatErrors(add.ErrorsByStatus))
+}
+
+func TestReportFiles(t *testing.T) {
+	r := newRecorder()
+	r.record(opList, 2*time.Millisecond, nil)
+	r.record(opList, 2*time.Millisecond, &taskclient.APIError{StatusCode: http.StatusTooManyRequests})
+	report := r.report(time.Second)
+	dir := t.TempDir()
+
+	jsonPath := filepath.Join(dir, "run.json")
+	assert.Nil(t, report.writeFile(jsonPath))
+	read, err := readReport(jsonPath)
+	assert.Nil(t, err)
+	assert.Equal(t, report.Operations, read.Operations)
+	assert.True(t, report.Started.Equal(read.Started))
+
+	csvPath := filepath.Join(dir, "run.csv")
+	assert.Nil(t, report.writeFile(csvPath))
+	data, err := os.ReadFile(csvPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "op,requests,errors,throughput,mean_ms,p50_ms,p90_ms,p99_ms,max_ms,errors_by_status\n"+
+		"list,2,1,2.000,2.000,2.000,2.000,2.000,2.000,429=1\n", string(data))
+}
+
+func testReport(p99 float64, throughput float64, errors int64) *Report {
+	return &Report{Operations: map[string]*OpReport{
+		opList: {Requests: 100, Errors: errors, Throughput: throughput, P50: 10, P90: 20, P99: p99},
+	}}
+}
+
+func TestCompareReports(t *testing.T) {
+	base := testReport(50, 100, 0)
+	regressed := func(c *Report) []string {
+		var metrics []string
+		for _, cmp := range compareReports(base, c, 0.1, 0.01) {
+			if cmp.Regression {
+				metrics = append(metrics, cmp.Metric)
+			}
+		}
+		return metrics
+	}
+	assert.Nil(t, regressed(testReport(54, 95, 1)))
+	assert.Equal(t, []string{"p99_ms"}, regressed(testReport(60, 100, 0)))
+	assert.Equal(t, []string{"throughput"}, regressed(testReport(50, 80, 0)))
+	assert.Equal(t, []string{"error_rate"}, regressed(testReport(50, 100, 5)))
+	assert.Len(t, compareReports(base, &Report{}, 0.1, 0.01), 0)
+}
+
+func TestReportCommand(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base.json")
+	candidatePath := filepath.Join(dir, "candidate.json")
+	assert.Nil(t, testReport(50, 100, 0).writeFile(basePath))
+	assert.Nil(t, testReport(80, 100, 0).writeFile(candidatePath))
+
+	code, out, _ := runCLI("report", "compare", basePath, basePath)
+	assert.Equal(t, exitOK, code)
+	assert.NotContains(t, out, "REGRESSION")
+
+	code, out, stderr := runCLI("report", "compare", basePath, candidatePath)
+	assert.Equal(t, exitRegression, code)
+	assert.Contains(t, out, "list  p99_ms      50.000   80.000     +60.0%  REGRESSION")
+	assert.Contains(t, stderr, "1 metrics regressed")
+
+	code, out, _ = runCLI("report", "show", basePath, "-o", "plain")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "list  100  0  100.0")
+
+	code, _, _ = runCLI("report", "compare", basePath)
+	assert.Equal(t, exitUsage, code)
+}
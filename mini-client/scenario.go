@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// Scenario is a scripted user journey the load generator runs over and over. Each run is one
+// iteration: its steps run in order with their own variables, starting from Vars plus worker
+// and iteration. Task fields and IDs are Go templates over those variables, e.g. "{{.bug}}".
+type Scenario struct {
+	Name  string            `yaml:"name"`
+	Vars  map[string]string `yaml:"vars"`
+	Steps []Step            `yaml:"steps"`
+
+	templates map[string]*template.Template
+}
+
+// Step is exactly one of an operation (Op), a pause (Think), a Loop or a weighted Choose
+type Step struct {
+	Name string `yaml:"name"` // what the step is reported as, the op if empty
+
+	Op            string   `yaml:"op"` // add, list, get, complete, reopen, edit or delete
+	ID            string   `yaml:"id"`
+	Title         string   `yaml:"title"`
+	Description   string   `yaml:"description"`
+	Assignees     []string `yaml:"assignees"`
+	ShowCompleted bool     `yaml:"show-completed"`
+	Capture       string   `yaml:"capture"` // variable the ID of the returned task is saved in
+	Expect        *Expect  `yaml:"expect"`
+
+	Think  string   `yaml:"think"` // a duration like 500ms, or a range like 100ms-2s
+	Loop   *Loop    `yaml:"loop"`
+	Choose []Branch `yaml:"choose"`
+
+	thinkMin time.Duration
+	thinkMax time.Duration
+}
+
+// Expect is checked against the response, a failed check ends the iteration
+type Expect struct {
+	Status    int    `yaml:"status"` // an error status the call must fail with, 0 or 2xx means it must succeed
+	Title     string `yaml:"title"`
+	Completed *bool  `yaml:"completed"`
+	MinCount  *int   `yaml:"min-count"` // list only
+	MaxCount  *int   `yaml:"max-count"` // list only
+}
+
+type Loop struct {
+	Times int    `yaml:"times"`
+	Steps []Step `yaml:"steps"`
+}
+
+type Branch struct {
+	Weight int    `yaml:"weight"`
+	Steps  []Step `yaml:"steps"`
+}
+
+// scenarioOps are the operations a step can run and whether they need an id
+var scenarioOps = map[string]bool{
+	opAdd: false, opList: false, "get": true, opComplete: true, "reopen": true, "edit": true, opDelete: true,
+}
+
+var (
+	// errAssertion marks a response that did not meet a step's expectations
+	errAssertion = errors.New("assertion failed")
+	// errTemplate marks a template that could not be filled in, usually a variable never captured
+	errTemplate = errors.New("template failed")
+)
+
+// loadScenario reads a scenario from a YAML or JSON file
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing scenario %s: %w", path, err)
+	}
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+var templateFuncs = template.FuncMap{
+	// randInt returns a number in [min, max]
+	"randInt": func(min int, max int) int { return min + rand.Intn(max-min+1) },
+}
+
+// compile checks every step and parses its templates and think times
+func (s *Scenario) compile() error {
+	if len(s.Steps) == 0 {
+		return errors.New("no steps")
+	}
+	s.templates = make(map[string]*template.Template)
+	return s.compileSteps(s.Steps, "steps")
+}
+
+func (s *Scenario) compileSteps(steps []Step, path string) error {
+	for i := range steps {
+		step := &steps[i]
+		where := fmt.Sprintf("%s[%d]", path, i)
+		kinds := 0
+		for _, set := range []bool{step.Op != "", step.Think != "", step.Loop != nil, step.Choose != nil} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return fmt.Errorf("%s: a step needs exactly one of op, think, loop or choose", where)
+		}
+		switch {
+		case step.Op != "":
+			needsID, ok := scenarioOps[step.Op]
+			if !ok {
+				return fmt.Errorf("%s: unknown op %q", where, step.Op)
+			}
+			if needsID && step.ID == "" {
+				return fmt.Errorf("%s: %s needs an id", where, step.Op)
+			}
+			texts := append([]string{step.ID, step.Title, step.Description}, step.Assignees...)
+			if step.Expect != nil {
+				texts = append(texts, step.Expect.Title)
+			}
+			for _, text := range texts {
+				if err := s.parseTemplate(text); err != nil {
+					return fmt.Errorf("%s: %w", where, err)
+				}
+			}
+		case step.Think != "":
+			var err error
+			if step.thinkMin, step.thinkMax, err = parseThink(step.Think); err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+		case step.Loop != nil:
+			if step.Loop.Times < 1 {
+				return fmt.Errorf("%s: loop times must be at least 1", where)
+			}
+			if err := s.compileSteps(step.Loop.Steps, where+".loop.steps"); err != nil {
+				return err
+			}
+		default:
+			if len(step.Choose) == 0 {
+				return fmt.Errorf("%s: choose needs at least one branch", where)
+			}
+			for j, branch := range step.Choose {
+				if branch.Weight < 1 {
+					return fmt.Errorf("%s.choose[%d]: weight must be at least 1", where, j)
+				}
+				if err := s.compileSteps(branch.Steps, fmt.Sprintf("%s.choose[%d].steps", where, j)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scenario) parseTemplate(text string) error {
+	if text == "" || s.templates[text] != nil {
+		return nil
+	}
+	t, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	s.templates[text] = t
+	return nil
+}
+
+// render fills in a template that compile parsed
+func (s *Scenario) render(text string, vars map[string]interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	var b strings.Builder
+	if err := s.templates[text].Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("%w: %v", errTemplate, err)
+	}
+	return b.String(), nil
+}
+
+// parseThink reads a think time, either a duration or a min-max range of durations
+func parseThink(s string) (time.Duration, time.Duration, error) {
+	low, high, isRange := strings.Cut(s, "-")
+	min, err := time.ParseDuration(strings.TrimSpace(low))
+	if err != nil {
+		return 0, 0, fmt.Errorf("think %q: %w", s, err)
+	}
+	if !isRange {
+		return min, min, nil
+	}
+	max, err := time.ParseDuration(strings.TrimSpace(high))
+	if err != nil {
+		return 0, 0, fmt.Errorf("think %q: %w", s, err)
+	}
+	if max < min {
+		return 0, 0, fmt.Errorf("think %q: range ends before it starts", s)
+	}
+	return min, max, nil
+}
+
+// iteration runs the scenario once, as a single trace. It stops at the first failed step,
+// whatever that step would have captured is missing for the steps after it. stop only cuts think
+// times short, requests in flight are allowed to finish.
+func (l *loadgen) iteration(stop context.Context, r *rand.Rand, worker int, iteration int) error {
+	vars := map[string]interface{}{"worker": worker, "iteration": iteration}
+	for k, v := range l.scenario.Vars {
+		vars[k] = v
+	}
+	ctx, span := l.tracing().StartSpan(context.Background(), "loadgen.scenario")
+	defer span.Finish()
+	span.SetTag("scenario", l.scenario.Name)
+	span.SetTag("worker", worker)
+	span.SetTag("iteration", iteration)
+	err := l.runSteps(ctx, stop, r, l.scenario.Steps, vars)
+	if err != nil {
+		span.SetError(err)
+	}
+	return err
+}
+
+// runSteps runs steps in order, ctx carries the iteration's span
+func (l *loadgen) runSteps(ctx context.Context, stop context.Context, r *rand.Rand, steps []Step, vars map[string]interface{}) error {
+	for i := range steps {
+		step := &steps[i]
+		var err error
+		switch {
+		case step.Op != "":
+			err = l.runOp(ctx, step, vars)
+		case step.Think != "":
+			d := step.thinkMin
+			if step.thinkMax > step.thinkMin {
+				d += time.Duration(r.Int63n(int64(step.thinkMax - step.thinkMin)))
+			}
+			err = sleepContext(stop, d)
+		case step.Loop != nil:
+			for n := 0; n < step.Loop.Times && err == nil; n++ {
+				vars["loop"] = n
+				err = l.runSteps(ctx, stop, r, step.Loop.Steps, vars)
+			}
+		default:
+			err = l.runSteps(ctx, stop, r, pickBranch(r, step.Choose).Steps, vars)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pickBranch(r *rand.Rand, branches []Branch) Branch {
+	total := 0
+	for _, b := range branches {
+		total += b.Weight
+	}
+	n := r.Intn(total)
+	for _, b := range branches {
+		if n < b.Weight {
+			return b
+		}
+		n -= b.Weight
+	}
+	return branches[len(branches)-1]
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// runOp sends one step's request, records it under the step's name and checks its expectations
+func (l *loadgen) runOp(ctx context.Context, step *Step, vars map[string]interface{}) error {
+	s := l.scenario
+	name := step.Name
+	if name == "" {
+		name = step.Op
+	}
+	var id int64
+	if step.ID != "" {
+		rendered, err := s.render(step.ID, vars)
+		if err != nil {
+			return fmt.Errorf("%s: id: %w", name, err)
+		}
+		if id, err = strconv.ParseInt(rendered, 10, 64); err != nil {
+			return fmt.Errorf("%s: id: %w: %q is not a number", name, errTemplate, rendered)
+		}
+	}
+	title, err := s.render(step.Title, vars)
+	if err != nil {
+		return fmt.Errorf("%s: title: %w", name, err)
+	}
+	description, err := s.render(step.Description, vars)
+	if err != nil {
+		return fmt.Errorf("%s: description: %w", name, err)
+	}
+	var assignees []string
+	for _, a := range step.Assignees {
+		rendered, err := s.render(a, vars)
+		if err != nil {
+			return fmt.Errorf("%s: assignees: %w", name, err)
+		}
+		assignees = append(assignees, rendered)
+	}
+
+	ctx, span := l.tracing().StartSpan(ctx, "loadgen.step")
+	defer span.Finish()
+	span.SetTag("step", name)
+	span.SetTag("operation", step.Op)
+	var task taskclient.Task
+	var tasks []taskclient.Task
+	start := time.Now()
+	switch step.Op {
+	case opAdd:
+		if id == 0 {
+			id = l.pool.newID()
+		}
+		task, err = l.tasks.AddTask(ctx, taskclient.Task{Id: id, Title: title, Description: description, Assignees: assignees})
+	case opList:
+		tasks, err = l.tasks.ListTasks(ctx, step.ShowCompleted)
+	case "get":
+		task, err = l.tasks.GetTask(ctx, id)
+	case opComplete:
+		task, err = l.tasks.CompleteTask(ctx, id)
+	case "reopen":
+		task, err = l.tasks.ReopenTask(ctx, id)
+	case "edit":
+		edit := taskclient.TaskEdit{Id: id}
+		if step.Title != "" {
+			edit.Title = &title
+		}
+		if step.Description != "" {
+			edit.Description = &description
+		}
+		if step.Assignees != nil {
+			edit.Assignees = &assignees
+		}
+		task, err = l.tasks.EditTask(ctx, edit)
+	case opDelete:
+		err = l.tasks.DeleteTask(ctx, id)
+	}
+	elapsed := time.Since(start)
+
+	err = s.check(step, vars, err, task, tasks)
+	l.observe(name, step.Op, elapsed, err)
+	if err != nil {
+		span.SetError(err)
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if step.Capture != "" {
+		vars[step.Capture] = task.Id
+	}
+	return nil
+}
+
+// check compares a step's outcome with its expectations, err is what the call returned
+func (s *Scenario) check(step *Step, vars map[string]interface{}, err error, task taskclient.Task, tasks []taskclient.Task) error {
+	expect := step.Expect
+	if expect != nil && expect.Status >= 300 {
+		var apiErr *taskclient.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != expect.Status {
+			return fmt.Errorf("%w: expected status %d, got %v", errAssertion, expect.Status, err)
+		}
+		return nil
+	}
+	if err != nil || expect == nil {
+		return err
+	}
+	if expect.Title != "" {
+		title, err := s.render(expect.Title, vars)
+		if err != nil {
+			return err
+		}
+		if task.Title != title {
+			return fmt.Errorf("%w: expected title %q, got %q", errAssertion, title, task.Title)
+		}
+	}
+	if expect.Completed != nil && task.Completed != *expect.Completed {
+		return fmt.Errorf("%w: expected completed %t, got %t", errAssertion, *expect.Completed, task.Completed)
+	}
+	if expect.MinCount != nil && len(tasks) < *expect.MinCount {
+		return fmt.Errorf("%w: expected at least %d tasks, got %d", errAssertion, *expect.MinCount, len(tasks))
+	}
+	if expect.MaxCount != nil && len(tasks) > *expect.MaxCount {
+		return fmt.Errorf("%w: expected at most %d tasks, got %d", errAssertion, *expect.MaxCount, len(tasks))
+	}
+	return nil
+}
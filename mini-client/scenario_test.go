@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+)
+
+// taskServer keeps tasks in memory and answers like task-manager does for JSON callers
+func taskServer() *httptest.Server {
+	var mu sync.Mutex
+	tasks := map[int64]*taskclient.Task{}
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var body struct {
+			taskclient.Task
+			Assignees *[]string `json:"assignees"`
+			Completed *bool     `json:"completed"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		// GET /tasks/{id} falls through to answering with the task
+		if id, err := strconv.ParseInt(strings.TrimPrefix(req.URL.Path, "/tasks/"), 10, 64); err == nil {
+			body.Id = id
+		}
+		task, found := tasks[body.Id]
+		switch req.URL.Path {
+		case "/tasks":
+			list := []taskclient.Task{}
+			for _, t := range tasks {
+				list = append(list, *t)
+			}
+			json.NewEncoder(res).Encode(list)
+			return
+		case "/tasks/add":
+			added := body.Task
+			tasks[added.Id] = &added
+			res.WriteHeader(http.StatusCreated)
+			json.NewEncoder(res).Encode(added)
+			return
+		}
+		if !found {
+			http.Error(res, fmt.Sprintf("No task with ID = %d", body.Id), http.StatusNotFound)
+			return
+		}
+		switch req.URL.Path {
+		case "/tasks/complete":
+			if task.Completed {
+				http.Error(res, "already completed", http.StatusConflict)
+				return
+			}
+			task.Completed = true
+		case "/tasks/edit":
+			if body.Assignees != nil {
+				task.Assignees = *body.Assignees
+			}
+			if body.Completed != nil {
+				task.Completed = *body.Completed
+			}
+		case "/tasks/delete":
+			delete(tasks, body.Id)
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(res).Encode(task)
+	}))
+}
+
+func scenarioLoadgen(url string, s *Scenario) *loadgen {
+	return &loadgen{tasks: taskclient.New(url), workers: 1, scenario: s, pool: &taskPool{}, recorder: newRecorder()}
+}
+
+func TestExampleScenarioRuns(t *testing.T) {
+	srv := taskServer()
+	defer srv.Close()
+	s, err := loadScenario(filepath.Join("scenarios", "triage.yaml"))
+	assert.Nil(t, err)
+	// no need to wait in a test
+	s.Steps[1].thinkMin, s.Steps[1].thinkMax = 0, 0
+	s.Steps[4].Loop.Steps[1].thinkMin, s.Steps[4].Loop.Steps[1].thinkMax = 0, 0
+
+	l := scenarioLoadgen(srv.URL, s)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, l.iteration(context.Background(), r, 0, i))
+	}
+	report := l.recorder.report(0)
+	assert.Equal(t, int64(0), report.Errors)
+	assert.Equal(t, int64(10), report.Operations["file-bug"].Requests)
+	assert.Equal(t, int64(20), report.Operations["get"].Requests)
+	assert.Equal(t, int64(10), report.Operations["gone"].Requests)
+	assert.Equal(t, int64(10), report.Operations["fix-bug"].Requests+report.Operations["hand-over"].Requests)
+}
+
+func writeScenario(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestScenarioFailures(t *testing.T) {
+	srv := taskServer()
+	defer srv.Close()
+
+	s, err := loadScenario(writeScenario(t, `
+steps:
+  - op: add
+    title: one
+    expect:
+      title: two
+  - op: list
+`))
+	assert.Nil(t, err)
+	l := scenarioLoadgen(srv.URL, s)
+	err = l.iteration(context.Background(), rand.New(rand.NewSource(1)), 0, 0)
+	assert.ErrorIs(t, err, errAssertion)
+	report := l.recorder.report(0)
+	assert.Equal(t, map[string]int64{"assertion": 1}, report.Operations[opAdd].ErrorsByStatus)
+	assert.Nil(t, report.Operations[opList], "the iteration stops at the failed step")
+
+	s, err = loadScenario(writeScenario(t, `
+steps:
+  - op: complete
+    id: "{{.never}}"
+`))
+	assert.Nil(t, err)
+	err = scenarioLoadgen(srv.URL, s).iteration(context.Background(), rand.New(rand.NewSource(1)), 0, 0)
+	assert.ErrorIs(t, err, errTemplate)
+}
+
+func TestScenarioValidation(t *testing.T) {
+	for _, bad := range []string{
+		"steps: []",
+		"steps:\n  - op: fly",
+		"steps:\n  - op: complete",
+		"steps:\n  - op: add\n    think: 1s",
+		"steps:\n  - think: soon",
+		"steps:\n  - think: 2s-1s",
+		"steps:\n  - loop:\n      times: 0\n      steps: []",
+		"steps:\n  - choose: []",
+		"steps:\n  - choose:\n      - weight: 0\n        steps: []",
+		"steps:\n  - op: add\n    title: '{{.oops'",
+		"steps:\n  - op: add\n    colour: red",
+	} {
+		_, err := loadScenario(writeScenario(t, bad))
+		assert.NotNil(t, err, bad)
+	}
+
+	// JSON is YAML too
+	s, err := loadScenario(writeScenario(t, `{"steps": [{"op": "list"}, {"think": "10ms-20ms"}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "10ms", s.Steps[1].thinkMin.String())
+	assert.Equal(t, "20ms", s.Steps[1].thinkMax.String())
+}
+
+func TestLoadgenRunsScenario(t *testing.T) {
+	srv := taskServer()
+	defer srv.Close()
+	path := writeScenario(t, `
+steps:
+  - op: add
+    title: "task {{.iteration}}"
+    capture: id
+  - op: complete
+    id: "{{.id}}"
+`)
+	code, out, _ := runCLI("loadgen", "-url", srv.URL, "-scenario", path, "-rate", "20", "-duration", "200ms", "-o", "json")
+	assert.Equal(t, exitOK, code)
+	var report Report
+	assert.Nil(t, json.Unmarshal([]byte(out), &report))
+	assert.Equal(t, int64(0), report.Errors)
+	assert.Greater(t, report.Operations[opScenario].Requests, int64(0))
+	assert.Equal(t, report.Operations[opScenario].Requests, report.Operations[opComplete].Requests)
+	// the totals are the step requests, an iteration is not counted again
+	assert.Equal(t, report.Operations[opAdd].Requests+report.Operations[opComplete].Requests, report.Requests)
+}
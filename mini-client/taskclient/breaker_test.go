@@ -0,0 +1,65 @@
+package taskclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	assert.Nil(t, b.allow())
+	b.record(false)
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Nil(t, b.allow())
+	b.record(false)
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.True(t, errors.Is(b.allow(), ErrCircuitOpen))
+
+	// after the timeout a single probe goes through
+	now = now.Add(time.Minute)
+	assert.Nil(t, b.allow())
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.True(t, errors.Is(b.allow(), ErrCircuitOpen))
+
+	// a failed probe opens it again
+	b.record(false)
+	assert.Equal(t, BreakerOpen, b.State())
+
+	now = now.Add(time.Minute)
+	assert.Nil(t, b.allow())
+	b.record(true)
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Nil(t, b.allow())
+}
+
+func TestClientFailsFastWhenBreakerOpen(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+		http.Error(res, "broken", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	metrics := &recordedMetrics{}
+	c := New(srv.URL)
+	c.Retry = RetryPolicy{MaxAttempts: 1}
+	c.Breaker = NewBreaker(3, time.Minute)
+	c.Metrics = metrics
+	for i := 0; i < 3; i++ {
+		_, err := c.ListTasks(context.Background(), true)
+		assert.True(t, errors.Is(err, ErrServer))
+	}
+	_, err := c.ListTasks(context.Background(), true)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, int64(1), metrics.counts["taskclient.breaker.rejected.count"])
+}
@@ -0,0 +1,347 @@
+// Package taskclient is a typed client for the task-manager HTTP API.
+package taskclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyHeader         = "X-API-Key"
+	tenantHeader         = "X-Tenant-ID"
+	idempotencyKeyHeader = "Idempotency-Key"
+)
+
+type Task struct {
+	Id          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Completed   bool     `json:"completed"`
+	Owner       string   `json:"owner,omitempty"`
+	Assignees   []string `json:"assignees,omitempty"`
+}
+
+// TaskEdit changes the fields that are set and leaves the rest alone
+type TaskEdit struct {
+	Id          int64     `json:"id"`
+	Title       *string   `json:"title,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Assignees   *[]string `json:"assignees,omitempty"`
+	Completed   *bool     `json:"completed,omitempty"`
+}
+
+// List is a named task list and how many tasks it holds
+type List struct {
+	Name  string `json:"name"`
+	Tasks int    `json:"tasks"`
+}
+
+// Client talks to one task-manager. The zero List uses the default list behind the /tasks routes.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	APIKey     string
+	Tenant     string
+	List       string
+
+	Retry   RetryPolicy
+	Breaker *Breaker // nil never trips, share one between clients of the same server
+	Metrics Metrics  // nil sends no metrics
+	Tracer  Tracer   // nil sends no trace headers
+}
+
+// New returns a client for the task-manager at baseURL, e.g. http://localhost:9000
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Retry:      DefaultRetryPolicy(),
+	}
+}
+
+// InList returns a copy of the client working on the named list
+func (c *Client) InList(name string) *Client {
+	copy := *c
+	copy.List = name
+	return &copy
+}
+
+// tasksPath returns the path of a task route, route is "" or a suffix like "/add"
+func (c *Client) tasksPath(route string) string {
+	if c.List == "" {
+		return "/tasks" + route
+	}
+	return "/lists/" + url.PathEscape(c.List) + "/tasks" + route
+}
+
+func (c *Client) metrics() Metrics {
+	if c.Metrics == nil {
+		return noopMetrics{}
+	}
+	return c.Metrics
+}
+
+func (c *Client) tracer() Tracer {
+	if c.Tracer == nil {
+		return noopTracer{}
+	}
+	return c.Tracer
+}
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey makes POST and PATCH calls made with ctx send key instead of a fresh one, so
+// a call replayed much later is still recognised by the server
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// NewIdempotencyKey returns a random key, for WithIdempotencyKey
+func NewIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// send makes one attempt at a request under its own span, whose context goes to the server in
+// the trace headers
+func (c *Client) send(ctx context.Context, method string, path string, data []byte, idempotencyKey string, attempt int) (*http.Response, error) {
+	ctx, span := c.tracer().StartSpan(ctx, "http.request")
+	defer span.Finish()
+	span.SetTag("span.kind", "client")
+	span.SetTag("http.method", method)
+	span.SetTag("http.url", c.BaseURL+path)
+	span.SetTag("attempt", attempt)
+	resp, err := c.attempt(ctx, method, path, data, idempotencyKey, span)
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetTag("http.status_code", resp.StatusCode)
+	}
+	return resp, err
+}
+
+// attempt sends the request, going through the breaker if there is one
+func (c *Client) attempt(ctx context.Context, method string, path string, data []byte, idempotencyKey string, span Span) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set(apiKeyHeader, c.APIKey)
+	}
+	if c.Tenant != "" {
+		req.Header.Set(tenantHeader, c.Tenant)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+	}
+	inject(req.Header, span.Context())
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if c.Breaker == nil {
+		return httpClient.Do(req)
+	}
+
+	if err := c.Breaker.allow(); err != nil {
+		c.metrics().Count("taskclient.breaker.rejected.count", 1, []string{"method:" + method})
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	switch {
+	case ctx.Err() != nil:
+		c.Breaker.abandon()
+	case err != nil || resp.StatusCode >= 500:
+		c.Breaker.record(false)
+	default:
+		c.Breaker.record(true)
+	}
+	c.metrics().Gauge("taskclient.breaker.state.gauge", float64(c.Breaker.State()), nil)
+	return resp, err
+}
+
+// do runs the call for operation op in a taskclient.<op> span, recording how long it took and
+// how it ended: the status code of the last response or, without one, why it failed
+func (c *Client) do(ctx context.Context, op string, method string, path string, in interface{}, out interface{}) error {
+	start := time.Now()
+	spanCtx, span := c.tracer().StartSpan(ctx, "taskclient."+op)
+	defer span.Finish()
+	status, err := c.roundTrip(spanCtx, method, path, in, out)
+	outcome := strconv.Itoa(status)
+	if status == 0 {
+		outcome = failureKind(ctx, err)
+	}
+	span.SetTag("operation", op)
+	span.SetTag("status", outcome)
+	if err != nil {
+		span.SetError(err)
+	}
+	tags := []string{"operation:" + op, "status:" + outcome}
+	c.metrics().Count("taskclient.requests.count", 1, tags)
+	c.metrics().Histogram("taskclient.request.duration_seconds", time.Since(start).Seconds(), tags)
+	return err
+}
+
+// failureKind says why a call got no response at all
+func failureKind(ctx context.Context, err error) string {
+	var t interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case ctx.Err() != nil:
+		return "canceled"
+	case errors.As(err, &t) && t.Timeout():
+		return "timeout"
+	}
+	return "network"
+}
+
+// roundTrip sends a JSON request, retrying it as the policy allows, and decodes the JSON response
+// into out, if out is not nil. It returns the status of the last response, 0 if there was none.
+func (c *Client) roundTrip(ctx context.Context, method string, path string, in interface{}, out interface{}) (int, error) {
+	var data []byte
+	if in != nil {
+		var err error
+		if data, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+	// a key lets the server recognise a retried POST or PATCH and replay its first answer
+	idempotencyKey := ""
+	if method == http.MethodPost || method == http.MethodPatch {
+		idempotencyKey, _ = ctx.Value(idempotencyKeyContextKey{}).(string)
+		if idempotencyKey == "" {
+			idempotencyKey = NewIdempotencyKey()
+		}
+	}
+
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		var err error
+		resp, err = c.send(ctx, method, path, data, idempotencyKey, attempt)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		retry, reason := retryable(err, status)
+		if !retry || attempt >= c.Retry.MaxAttempts || !idempotent(method, idempotencyKey) || ctx.Err() != nil {
+			if err != nil {
+				return 0, err
+			}
+			break
+		}
+		delay := c.Retry.backoff(attempt - 1)
+		if wait, ok := retryAfter(resp, time.Now()); ok && wait > delay {
+			delay = wait
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		c.metrics().Count("taskclient.retries.count", 1, []string{"method:" + method, "reason:" + reason})
+		if err := sleep(ctx, delay); err != nil {
+			return 0, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, newAPIError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("decoding %s %s response: %w", method, path, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// ListTasks returns the tasks the caller can see, leaving out completed ones unless showCompleted is set
+func (c *Client) ListTasks(ctx context.Context, showCompleted bool) ([]Task, error) {
+	var tasks []Task
+	err := c.do(ctx, "list_tasks", http.MethodGet, c.tasksPath("")+"?showCompleted="+strconv.FormatBool(showCompleted), nil, &tasks)
+	return tasks, err
+}
+
+// GetTask returns a single task, it fails with ErrNotFound if the caller cannot see a task with that id
+func (c *Client) GetTask(ctx context.Context, id int64) (Task, error) {
+	var task Task
+	err := c.do(ctx, "get_task", http.MethodGet, c.tasksPath("/"+strconv.FormatInt(id, 10)), nil, &task)
+	return task, err
+}
+
+// AddTask creates a task and returns it as stored, with its owner set
+func (c *Client) AddTask(ctx context.Context, task Task) (Task, error) {
+	var created Task
+	err := c.do(ctx, "add_task", http.MethodPost, c.tasksPath("/add"), task, &created)
+	return created, err
+}
+
+// CompleteTask marks a task completed, it fails with ErrNotFound or, if it already is, ErrConflict
+func (c *Client) CompleteTask(ctx context.Context, id int64) (Task, error) {
+	var completed Task
+	err := c.do(ctx, "complete_task", http.MethodPatch, c.tasksPath("/complete"), map[string]int64{"id": id}, &completed)
+	return completed, err
+}
+
+// ReopenTask marks a completed task as not completed
+func (c *Client) ReopenTask(ctx context.Context, id int64) (Task, error) {
+	completed := false
+	return c.editTask(ctx, "reopen_task", TaskEdit{Id: id, Completed: &completed})
+}
+
+// EditTask changes a task's title, description, assignees or completion
+func (c *Client) EditTask(ctx context.Context, edit TaskEdit) (Task, error) {
+	return c.editTask(ctx, "edit_task", edit)
+}
+
+func (c *Client) editTask(ctx context.Context, op string, edit TaskEdit) (Task, error) {
+	var edited Task
+	err := c.do(ctx, op, http.MethodPatch, c.tasksPath("/edit"), edit, &edited)
+	return edited, err
+}
+
+// DeleteTask removes a single task
+func (c *Client) DeleteTask(ctx context.Context, id int64) error {
+	return c.do(ctx, "delete_task", http.MethodDelete, c.tasksPath("/delete"), map[string]int64{"id": id}, nil)
+}
+
+// ClearTasks removes every task in the list, it needs the tasks:admin scope
+func (c *Client) ClearTasks(ctx context.Context) error {
+	return c.do(ctx, "clear_tasks", http.MethodDelete, c.tasksPath(""), nil, nil)
+}
+
+// Lists returns every list of the tenant
+func (c *Client) Lists(ctx context.Context) ([]List, error) {
+	var lists []List
+	err := c.do(ctx, "list_lists", http.MethodGet, "/lists", nil, &lists)
+	return lists, err
+}
+
+// CreateList creates a named list, it fails with ErrConflict if the list exists
+func (c *Client) CreateList(ctx context.Context, name string) (List, error) {
+	var list List
+	err := c.do(ctx, "create_list", http.MethodPost, "/lists", map[string]string{"name": name}, &list)
+	return list, err
+}
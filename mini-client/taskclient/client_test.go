@@ -0,0 +1,163 @@
+package taskclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientSendsCredentialsAndDecodesTasks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/json", req.Header.Get("Accept"))
+		assert.Equal(t, "tm_id_secret", req.Header.Get(apiKeyHeader))
+		assert.Equal(t, "teamA", req.Header.Get(tenantHeader))
+		switch req.URL.Path {
+		case "/lists/work/tasks/add":
+			var task Task
+			assert.Nil(t, json.NewDecoder(req.Body).Decode(&task))
+			task.Owner = "key:id"
+			res.WriteHeader(http.StatusCreated)
+			json.NewEncoder(res).Encode(task)
+		case "/lists/work/tasks":
+			assert.Equal(t, "false", req.URL.Query().Get("showCompleted"))
+			json.NewEncoder(res).Encode([]Task{{Id: 1, Title: "one"}})
+		default:
+			http.NotFound(res, req)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL + "/")
+	c.APIKey = "tm_id_secret"
+	c.Tenant = "teamA"
+	work := c.InList("work")
+	ctx := context.Background()
+
+	task, err := work.AddTask(ctx, Task{Id: 1, Title: "one"})
+	assert.Nil(t, err)
+	assert.Equal(t, Task{Id: 1, Title: "one", Owner: "key:id"}, task)
+
+	tasks, err := work.ListTasks(ctx, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{{Id: 1, Title: "one"}}, tasks)
+	assert.Equal(t, "", c.List)
+}
+
+func TestClientTypedErrors(t *testing.T) {
+	status := http.StatusNotFound
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		http.Error(res, "No task with ID = 9 to complete", status)
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Retry = RetryPolicy{MaxAttempts: 1}
+
+	cases := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusConflict, ErrConflict},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusServiceUnavailable, ErrServer},
+	}
+	for _, tc := range cases {
+		status = tc.status
+		_, err := c.CompleteTask(context.Background(), 9)
+		assert.True(t, errors.Is(err, tc.want), "status %d: %v", tc.status, err)
+		var apiErr *APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, tc.status, apiErr.StatusCode)
+		assert.Equal(t, "No task with ID = 9 to complete", apiErr.Message)
+	}
+}
+
+func TestClientHonoursContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := New(srv.URL).ListTasks(ctx, true)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestGetAndReopenTask(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/tasks/1":
+			json.NewEncoder(res).Encode(Task{Id: 1, Title: "one", Completed: true})
+		case "/tasks/edit":
+			body, _ := io.ReadAll(req.Body)
+			assert.JSONEq(t, `{"id":1,"completed":false}`, string(body))
+			json.NewEncoder(res).Encode(Task{Id: 1, Title: "one"})
+		default:
+			http.NotFound(res, req)
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	task, err := c.GetTask(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "one", task.Title)
+	_, err = c.GetTask(ctx, 3)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	task, err = c.ReopenTask(ctx, 1)
+	assert.Nil(t, err)
+	assert.False(t, task.Completed)
+}
+
+func TestClientMetricsPerOperationAndStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/tasks":
+			json.NewEncoder(res).Encode([]Task{{Id: 1, Title: "one", Completed: true}})
+		case "/tasks/1":
+			json.NewEncoder(res).Encode(Task{Id: 1, Title: "one", Completed: true})
+		case "/tasks/complete":
+			http.Error(res, "Task 1 is already completed", http.StatusConflict)
+		default:
+			http.NotFound(res, req)
+		}
+	}))
+	c := New(srv.URL)
+	c.Retry = RetryPolicy{MaxAttempts: 1}
+	metrics := &recordedMetrics{}
+	c.Metrics = metrics
+	ctx := context.Background()
+
+	c.ListTasks(ctx, true)
+	c.GetTask(ctx, 1)
+	c.CompleteTask(ctx, 1)
+	c.ReopenTask(ctx, 1)
+	srv.Close()
+	c.DeleteTask(ctx, 1)
+
+	assert.Equal(t, map[string]int64{
+		"taskclient.requests.count operation:list_tasks,status:200":      1,
+		"taskclient.requests.count operation:get_task,status:200":        1,
+		"taskclient.requests.count operation:complete_task,status:409":   1,
+		"taskclient.requests.count operation:reopen_task,status:404":     1,
+		"taskclient.requests.count operation:delete_task,status:network": 1,
+	}, metrics.tagged)
+	assert.Equal(t, []string{
+		"taskclient.request.duration_seconds operation:list_tasks,status:200",
+		"taskclient.request.duration_seconds operation:get_task,status:200",
+		"taskclient.request.duration_seconds operation:complete_task,status:409",
+		"taskclient.request.duration_seconds operation:reopen_task,status:404",
+		"taskclient.request.duration_seconds operation:delete_task,status:network",
+	}, metrics.histograms)
+}
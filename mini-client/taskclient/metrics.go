@@ -0,0 +1,16 @@
+package taskclient
+
+// Metrics receives the client's metrics, names use the DogStatsD dotted style and tags are key:value.
+// Every call counts as taskclient.requests.count and is timed as taskclient.request.duration_seconds,
+// both tagged with the operation and the status code, or why there was no response.
+type Metrics interface {
+	Count(name string, value int64, tags []string)
+	Gauge(name string, value float64, tags []string)
+	Histogram(name string, value float64, tags []string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Count(string, int64, []string)       {}
+func (noopMetrics) Gauge(string, float64, []string)     {}
+func (noopMetrics) Histogram(string, float64, []string) {}
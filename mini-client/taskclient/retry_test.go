@@ -0,0 +1,176 @@
+package taskclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedMetrics struct {
+	mu         sync.Mutex
+	counts     map[string]int64
+	tagged     map[string]int64 // counts by name and tags
+	histograms []string
+}
+
+func (m *recordedMetrics) Count(name string, value int64, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = make(map[string]int64)
+	}
+	m.counts[name] += value
+	if m.tagged == nil {
+		m.tagged = make(map[string]int64)
+	}
+	m.tagged[name+" "+strings.Join(tags, ",")] += value
+}
+
+func (m *recordedMetrics) Gauge(string, float64, []string) {}
+
+func (m *recordedMetrics) Histogram(name string, value float64, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.histograms = append(m.histograms, name+" "+strings.Join(tags, ","))
+}
+
+func fastRetry() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+}
+
+func TestRetriesUnavailableThenSucceeds(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		keys = append(keys, req.Header.Get(idempotencyKeyHeader))
+		if len(keys) < 3 {
+			http.Error(res, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		res.WriteHeader(http.StatusCreated)
+		res.Write([]byte(`{"id":1,"title":"one"}`))
+	}))
+	defer srv.Close()
+
+	metrics := &recordedMetrics{}
+	c := New(srv.URL)
+	c.Retry = fastRetry()
+	c.Metrics = metrics
+	task, err := c.AddTask(context.Background(), Task{Id: 1, Title: "one"})
+	assert.Nil(t, err)
+	assert.Equal(t, "one", task.Title)
+	assert.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.Equal(t, keys[0], keys[2])
+	assert.Equal(t, int64(2), metrics.counts["taskclient.retries.count"])
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+		http.Error(res, "slow down", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Retry = fastRetry()
+	_, err := c.ListTasks(context.Background(), true)
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, 3, calls)
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+		http.Error(res, "bad", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Retry = fastRetry()
+	_, err := c.ListTasks(context.Background(), true)
+	assert.True(t, errors.Is(err, ErrBadRequest))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			res.Header().Set("Retry-After", "1")
+			http.Error(res, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		res.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Retry = fastRetry()
+	_, err := c.ListTasks(context.Background(), true)
+	assert.Nil(t, err)
+	assert.Len(t, times, 2)
+	assert.GreaterOrEqual(t, times[1].Sub(times[0]), time.Second)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp := &http.Response{Header: http.Header{}}
+	_, ok := retryAfter(resp, now)
+	assert.False(t, ok)
+
+	resp.Header.Set("Retry-After", "3")
+	d, ok := retryAfter(resp, now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	resp.Header.Set("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+	d, ok = retryAfter(resp, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 0; attempt < 40; attempt++ {
+		d := p.backoff(attempt)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.Less(t, d, time.Second)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	assert.True(t, idempotent(http.MethodGet, ""))
+	assert.True(t, idempotent(http.MethodDelete, ""))
+	assert.False(t, idempotent(http.MethodPost, ""))
+	assert.True(t, idempotent(http.MethodPost, "key"))
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		keys = append(keys, req.Header.Get(idempotencyKeyHeader))
+		json.NewEncoder(res).Encode(Task{Id: 1})
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+
+	ctx := WithIdempotencyKey(context.Background(), "queued-1")
+	c.AddTask(ctx, Task{Id: 1})
+	c.AddTask(ctx, Task{Id: 1})
+	c.AddTask(context.Background(), Task{Id: 1})
+	assert.Equal(t, []string{"queued-1", "queued-1"}, keys[:2])
+	assert.NotEqual(t, "queued-1", keys[2])
+	assert.Len(t, keys[2], 32)
+}
@@ -0,0 +1,78 @@
+package taskclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+)
+
+// trace headers the client sends, task-manager reads them with either of its tracers
+const (
+	traceparentHeader     = "traceparent"
+	datadogTraceIDHeader  = "x-datadog-trace-id"
+	datadogParentIDHeader = "x-datadog-parent-id"
+	datadogPriorityHeader = "x-datadog-sampling-priority"
+	datadogTagsHeader     = "x-datadog-tags"
+)
+
+// Tracer starts the client's spans: one per call, named taskclient.<operation>, and a child
+// http.request span per attempt whose context is sent to the server. A span started from a ctx
+// that holds another span is its child.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single unit of work within a trace
+type Span interface {
+	SetTag(key string, value interface{})
+	// SetError marks the span as failed
+	SetError(err error)
+	Context() SpanContext
+	Finish()
+}
+
+// SpanContext is the part of a span that crosses process boundaries
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid is false for the zero SpanContext, which is not propagated
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// inject writes sc as a W3C traceparent and as Datadog headers. Datadog ids are the low 64 bits
+// in decimal, the high 64 bits of the trace id go in x-datadog-tags as _dd.p.tid.
+func inject(header http.Header, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	flags, priority := "00", "0"
+	if sc.Sampled {
+		flags, priority = "01", "1"
+	}
+	header.Set(traceparentHeader, "00-"+hex.EncodeToString(sc.TraceID[:])+"-"+hex.EncodeToString(sc.SpanID[:])+"-"+flags)
+	header.Set(datadogTraceIDHeader, strconv.FormatUint(binary.BigEndian.Uint64(sc.TraceID[8:]), 10))
+	header.Set(datadogParentIDHeader, strconv.FormatUint(binary.BigEndian.Uint64(sc.SpanID[:]), 10))
+	header.Set(datadogPriorityHeader, priority)
+	if high := sc.TraceID[:8]; binary.BigEndian.Uint64(high) != 0 {
+		header.Set(datadogTagsHeader, "_dd.p.tid="+hex.EncodeToString(high))
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetTag(string, interface{}) {}
+func (noopSpan) SetError(error)             {}
+func (noopSpan) Context() SpanContext       { return SpanContext{} }
+func (noopSpan) Finish()                    {}
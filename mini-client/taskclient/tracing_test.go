@@ -0,0 +1,101 @@
+package taskclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectWritesW3CAndDatadogHeaders(t *testing.T) {
+	sc := SpanContext{
+		TraceID: [16]byte{0, 0, 0, 0, 0, 0, 0, 0x2a, 0, 0, 0, 0, 0, 0, 0x01, 0x00},
+		SpanID:  [8]byte{0, 0, 0, 0, 0, 0, 0, 0x07},
+		Sampled: true,
+	}
+	header := http.Header{}
+	inject(header, sc)
+	assert.Equal(t, "00-000000000000002a0000000000000100-0000000000000007-01", header.Get("traceparent"))
+	assert.Equal(t, "256", header.Get("x-datadog-trace-id"))
+	assert.Equal(t, "7", header.Get("x-datadog-parent-id"))
+	assert.Equal(t, "1", header.Get("x-datadog-sampling-priority"))
+	assert.Equal(t, "_dd.p.tid=000000000000002a", header.Get("x-datadog-tags"))
+
+	header = http.Header{}
+	inject(header, SpanContext{})
+	assert.Empty(t, header)
+}
+
+// recordedTracer numbers its spans and remembers which span started each one
+type recordedTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent string
+	id     byte
+	tags   map[string]interface{}
+	err    error
+}
+
+type recordedSpanKey struct{}
+
+func (t *recordedTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordedSpan{name: name, id: byte(len(t.spans) + 1), tags: map[string]interface{}{}}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+func (s *recordedSpan) SetTag(key string, value interface{}) { s.tags[key] = value }
+func (s *recordedSpan) SetError(err error)                   { s.err = err }
+func (s *recordedSpan) Finish()                              {}
+func (s *recordedSpan) Context() SpanContext {
+	return SpanContext{TraceID: [16]byte{15: 1}, SpanID: [8]byte{7: s.id}, Sampled: true}
+}
+
+func TestClientSpansPerCallAndAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var parents []string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		parents = append(parents, req.Header.Get("x-datadog-parent-id"))
+		calls++
+		if calls == 1 {
+			http.Error(res, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		res.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Retry = fastRetry()
+	tracer := &recordedTracer{}
+	c.Tracer = tracer
+
+	_, err := c.ListTasks(context.Background(), false)
+	assert.Nil(t, err)
+	if !assert.Len(t, tracer.spans, 3) {
+		return
+	}
+	call, first, second := tracer.spans[0], tracer.spans[1], tracer.spans[2]
+	assert.Equal(t, "taskclient.list_tasks", call.name)
+	assert.Equal(t, "200", call.tags["status"])
+	assert.Equal(t, []string{"taskclient.list_tasks", "taskclient.list_tasks"}, []string{first.parent, second.parent})
+	assert.Equal(t, "http.request", first.name)
+	assert.Equal(t, 503, first.tags["http.status_code"])
+	assert.Equal(t, 2, second.tags["attempt"])
+	// each attempt is the parent of what the server does with it
+	assert.Equal(t, []string{"2", "3"}, parents)
+}
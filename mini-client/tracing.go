@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/DataDog/mini-golang-project/mini-client/taskclient"
+	"github.com/DataDog/mini-golang-project/telemetry"
+)
+
+const defaultOTLPService = "task-client"
+
+// Tracer traces what the CLI and its task client do
+type Tracer interface {
+	taskclient.Tracer
+	// Stop exports the spans not sent yet
+	Stop()
+}
+
+// tracingOptions configures the tracer newTracer builds
+type tracingOptions struct {
+	OTLPEndpoint string
+	Service      string
+	Tags         []string // key:value, sent as resource attributes
+	Errors       io.Writer
+}
+
+// newTracer builds the named tracer: otel, exporting to an OTLP/HTTP collector, or none
+func newTracer(backend string, opts tracingOptions) (Tracer, error) {
+	switch backend {
+	case "otel":
+		return newOTelTracer(opts), nil
+	case "none", "":
+		return noopTracer{}, nil
+	}
+	return nil, fmt.Errorf("unknown tracing backend %q, use otel or none", backend)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(ctx context.Context, name string) (context.Context, taskclient.Span) {
+	return ctx, noopSpan{}
+}
+func (noopTracer) Stop() {}
+
+type noopSpan struct{}
+
+func (noopSpan) SetTag(string, interface{})      {}
+func (noopSpan) SetError(error)                  {}
+func (noopSpan) Context() taskclient.SpanContext { return taskclient.SpanContext{} }
+func (noopSpan) Finish()                         {}
+
+// otelSpan exposes a telemetry span to the task client
+type otelSpan struct {
+	*telemetry.Span
+}
+
+func (s otelSpan) Context() taskclient.SpanContext {
+	return taskclient.SpanContext(s.Span.Context())
+}
+
+// otelTracer exports spans to an OTLP/HTTP collector
+type otelTracer struct {
+	*telemetry.Tracer
+}
+
+func newOTelTracer(opts tracingOptions) *otelTracer {
+	service := opts.Service
+	if service == "" {
+		service = defaultOTLPService
+	}
+	errors := opts.Errors
+	if errors == nil {
+		errors = io.Discard
+	}
+	exporter := telemetry.NewExporter(opts.OTLPEndpoint, service, opts.Tags)
+	return &otelTracer{telemetry.NewTracer(exporter, func(err error, spans int) {
+		fmt.Fprintf(errors, "tasks: exporting %d spans: %v\n", spans, err)
+	})}
+}
+
+// StartSpan starts a child of the span in ctx or, without one, the root of a new sampled trace
+func (t *otelTracer) StartSpan(ctx context.Context, name string) (context.Context, taskclient.Span) {
+	ctx, span := t.Start(ctx, name, telemetry.SpanKindInternal, nil)
+	return ctx, otelSpan{span}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
+)
+
+// collector stands in for an OTLP/HTTP collector, keeping every span it is sent
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	spans    []telemetry.OTLPSpan
+	resource telemetry.OTLPResource
+}
+
+func newCollector() *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" {
+			http.NotFound(res, req)
+			return
+		}
+		var payload telemetry.OTLPTracesRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, rs := range payload.ResourceSpans {
+			c.resource = rs.Resource
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	return c
+}
+
+// tracedServer serves tasks like taskServer and remembers the trace headers of each request
+func tracedServer() (*httptest.Server, func() []http.Header) {
+	tasks := taskServer()
+	var mu sync.Mutex
+	var headers []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		headers = append(headers, req.Header.Clone())
+		mu.Unlock()
+		tasks.Config.Handler.ServeHTTP(res, req)
+	}))
+	srv.Config.RegisterOnShutdown(tasks.Close)
+	return srv, func() []http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return headers
+	}
+}
+
+func TestScenarioIterationIsOneTrace(t *testing.T) {
+	col := newCollector()
+	defer col.Close()
+	srv, headers := tracedServer()
+	defer srv.Close()
+	s, err := loadScenario(writeScenario(t, `
+name: traced
+steps:
+  - name: create
+    op: add
+    title: traced
+    capture: task
+  - op: complete
+    id: "{{.task}}"
+`))
+	assert.Nil(t, err)
+	tracer, err := newTracer("otel", tracingOptions{OTLPEndpoint: col.URL, Tags: []string{"environment:ci"}})
+	assert.Nil(t, err)
+	l := scenarioLoadgen(srv.URL, s)
+	l.tasks.Tracer = tracer
+	l.tracer = tracer
+
+	assert.Nil(t, l.iteration(context.Background(), rand.New(rand.NewSource(1)), 0, 0))
+	tracer.Stop()
+
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	byName := map[string][]telemetry.OTLPSpan{}
+	byID := map[string]telemetry.OTLPSpan{}
+	for _, span := range col.spans {
+		byName[span.Name] = append(byName[span.Name], span)
+		byID[span.SpanID] = span
+	}
+	assert.Len(t, col.spans, 7)
+	root := byName["loadgen.scenario"]
+	if !assert.Len(t, root, 1) {
+		return
+	}
+	traceID := root[0].TraceID
+	assert.Empty(t, root[0].ParentSpanID)
+	for _, span := range col.spans {
+		assert.Equal(t, traceID, span.TraceID, span.Name)
+	}
+	assert.Len(t, byName["loadgen.step"], 2)
+	// scenario -> step -> client call -> attempt
+	add := byName["taskclient.add_task"][0]
+	step := byID[add.ParentSpanID]
+	assert.Equal(t, "loadgen.step", step.Name)
+	assert.Equal(t, root[0].SpanID, step.ParentSpanID)
+	assert.Contains(t, col.resource.Attributes, telemetry.Attribute("deployment.environment", "ci"))
+
+	// the server continues the trace from the http.request span of each call
+	requests := byName["http.request"]
+	seen := headers()
+	if !assert.Len(t, requests, 2) || !assert.Len(t, seen, 2) {
+		return
+	}
+	high, _ := hex.DecodeString(traceID[:16])
+	low, _ := hex.DecodeString(traceID[16:])
+	for i, header := range seen {
+		parts := strings.Split(header.Get("traceparent"), "-")
+		if !assert.Len(t, parts, 4) {
+			continue
+		}
+		assert.Equal(t, traceID, parts[1])
+		client, ok := byID[parts[2]]
+		assert.True(t, ok, "request %d names a span the collector never got", i)
+		assert.Equal(t, "http.request", client.Name)
+		assert.Equal(t, telemetry.SpanKindClient, client.Kind)
+		assert.Equal(t, strconv.FormatUint(binary.BigEndian.Uint64(low), 10), header.Get("x-datadog-trace-id"))
+		spanID, _ := hex.DecodeString(parts[2])
+		assert.Equal(t, strconv.FormatUint(binary.BigEndian.Uint64(spanID), 10), header.Get("x-datadog-parent-id"))
+		assert.Equal(t, "_dd.p.tid="+hex.EncodeToString(high), header.Get("x-datadog-tags"))
+	}
+}
+
+func TestNoTraceHeadersWithoutTracing(t *testing.T) {
+	srv, headers := tracedServer()
+	defer srv.Close()
+	code, _, _ := runCLI("list", "-url", srv.URL, "-tracing", "none")
+	assert.Equal(t, exitOK, code)
+	for _, header := range headers() {
+		assert.Empty(t, header.Get("traceparent"))
+		assert.Empty(t, header.Get("x-datadog-trace-id"))
+	}
+
+	code, _, errOut := runCLI("list", "-url", srv.URL, "-tracing", "zipkin")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, errOut, `unknown tracing backend "zipkin"`)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const apiKeyPrefix = "tm_"
+
+// APIKey is a stored key, only the hash of its secret is kept
+type APIKey struct {
+	Id        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Tenant    string    `json:"tenant"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+	transient bool      // registered from config on every start, never written to disk
+}
+
+type NewAPIKey struct {
+	Tenant string   `json:"tenant"`
+	Scopes []string `json:"scopes"`
+}
+
+// MintedAPIKey is returned once when a key is created, it is the only time the raw key is shown
+type MintedAPIKey struct {
+	Id     string   `json:"id"`
+	Key    string   `json:"key"`
+	Tenant string   `json:"tenant"`
+	Scopes []string `json:"scopes"`
+}
+
+var errInvalidKey = errors.New("invalid API key")
+
+// KeyStore holds hashed API keys, persisting them to a JSON file when a path is set
+type KeyStore struct {
+	mu   sync.Mutex
+	keys map[string]*APIKey
+	path string
+}
+
+// LoadKeyStore reads keys from path, a missing file starts an empty store
+func LoadKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{keys: make(map[string]*APIKey), path: path}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parsing API keys %s: %w", path, err)
+	}
+	for _, k := range keys {
+		s.keys[k.Id] = k
+	}
+	return s, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitKey breaks a raw key of the form tm_<id>_<secret> into its id and secret
+func splitKey(raw string) (string, string, bool) {
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return "", "", false
+	}
+	return strings.Cut(strings.TrimPrefix(raw, apiKeyPrefix), "_")
+}
+
+// Add registers an existing raw key, used for the bootstrap admin key
+func (s *KeyStore) Add(raw string, tenant string, scopes []string) error {
+	id, secret, ok := splitKey(raw)
+	if !ok || id == "" || secret == "" {
+		return errInvalidKey
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[id] = &APIKey{Id: id, Hash: hashSecret(secret), Tenant: tenant, Scopes: scopes, CreatedAt: time.Now().UTC(), transient: true}
+	return nil
+}
+
+// Mint creates and stores a new random key
+func (s *KeyStore) Mint(tenant string, scopes []string) (MintedAPIKey, error) {
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return MintedAPIKey{}, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return MintedAPIKey{}, err
+	}
+	id := hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[id] = &APIKey{Id: id, Hash: hashSecret(secret), Tenant: tenant, Scopes: scopes, CreatedAt: time.Now().UTC()}
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return MintedAPIKey{}, err
+	}
+	return MintedAPIKey{Id: id, Key: apiKeyPrefix + id + "_" + secret, Tenant: tenant, Scopes: scopes}, nil
+}
+
+// Revoke marks a key of tenant as revoked, an empty tenant matches every tenant. It returns
+// false if there is no such key.
+func (s *KeyStore) Revoke(id string, tenant string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok || tenant != "" && k.Tenant != tenant {
+		return false, nil
+	}
+	k.Revoked = true
+	return true, s.save()
+}
+
+// Verify checks a raw key and returns a copy of the stored key it matches, taken under the lock
+// Revoke holds
+func (s *KeyStore) Verify(raw string) (*APIKey, error) {
+	id, secret, ok := splitKey(raw)
+	if !ok {
+		return nil, errInvalidKey
+	}
+	s.mu.Lock()
+	stored, ok := s.keys[id]
+	var k APIKey
+	if ok {
+		k = *stored
+	}
+	s.mu.Unlock()
+	if !ok || k.Revoked {
+		return nil, errInvalidKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) != 1 {
+		return nil, errInvalidKey
+	}
+	return &k, nil
+}
+
+// List returns the keys of tenant sorted by id, without their hashes. An empty tenant lists
+// every tenant's keys.
+func (s *KeyStore) List(tenant string) []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		if tenant != "" && k.Tenant != tenant {
+			continue
+		}
+		key := *k
+		key.Hash = ""
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id < keys[j].Id })
+	return keys
+}
+
+// Flush writes the keys to disk, catching up on any earlier save that failed
+func (s *KeyStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// save writes the keys to disk, callers must hold s.mu
+func (s *KeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		if !k.transient {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id < keys[j].Id })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".api-keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// keysTenant returns the tenant whose keys the caller may manage, empty for an operator who may
+// manage every tenant's. Without authentication there is no caller to restrict.
+func keysTenant(req *http.Request) string {
+	identity, ok := identityFromContext(req.Context())
+	if !ok || identity.HasScope(scopeOperator) {
+		return ""
+	}
+	return identity.Tenant
+}
+
+// handler for /admin/keys (list keys and mint a new key), a caller only sees and mints keys of
+// its own tenant unless it is an operator
+func (s *KeyStore) KeysHandler(res http.ResponseWriter, req *http.Request) {
+	tenant := keysTenant(req)
+	switch req.Method {
+	case "GET":
+		res.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(res).Encode(s.List(tenant))
+	case "POST":
+		var newKey NewAPIKey
+		err := json.NewDecoder(req.Body).Decode(&newKey)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if newKey.Tenant == "" {
+			newKey.Tenant = tenant
+		}
+		if !listNamePattern.MatchString(newKey.Tenant) {
+			http.Error(res, "invalid tenant", http.StatusBadRequest)
+			return
+		}
+		if tenant != "" && newKey.Tenant != tenant {
+			http.Error(res, fmt.Sprintf("Minting keys for tenant %s requires the %s scope", newKey.Tenant, scopeOperator), http.StatusForbidden)
+			return
+		}
+		for _, scope := range newKey.Scopes {
+			if !validScope(scope) {
+				http.Error(res, fmt.Sprintf("Unknown scope %s", scope), http.StatusBadRequest)
+				return
+			}
+			if scope == scopeOperator && tenant != "" {
+				http.Error(res, fmt.Sprintf("Minting keys with the %s scope requires that scope", scopeOperator), http.StatusForbidden)
+				return
+			}
+		}
+		minted, err := s.Mint(newKey.Tenant, newKey.Scopes)
+		if err != nil {
+			loggerFromContext(req.Context()).WithError(err).Error("Failed to mint API key")
+			http.Error(res, "failed to mint key", http.StatusInternalServerError)
+			return
+		}
+		loggerFromContext(req.Context()).WithFields(log.Fields{"tenant": minted.Tenant, "key_id": minted.Id, "scopes": minted.Scopes}).Info("Minted API key")
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusCreated)
+		json.NewEncoder(res).Encode(minted)
+	default:
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handler for /admin/keys/{id} (revoke a key), keys of other tenants look like missing ones
+// unless the caller is an operator
+func (s *KeyStore) KeyHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "DELETE" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(req.URL.Path, "/admin/keys/")
+	found, err := s.Revoke(id, keysTenant(req))
+	if err != nil {
+		loggerFromContext(req.Context()).WithError(err).Error("Failed to revoke API key")
+		http.Error(res, "failed to revoke key", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(res, fmt.Sprintf("No key with id %s", id), http.StatusNotFound)
+		return
+	}
+	loggerFromContext(req.Context()).WithField("key_id", id).Info("Revoked API key")
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scopeTasksRead  = "tasks:read"
+	scopeTasksWrite = "tasks:write"
+	scopeTasksAdmin = "tasks:admin"
+	scopeKeysAdmin  = "keys:admin"
+	// scopeOperator is for whoever runs the server rather than a tenant: it guards /admin/,
+	// /debug/ and /metrics, which names every tenant, and is the only scope that can manage the keys of another tenant
+	scopeOperator = "server:admin"
+
+	apiKeyHeader = "X-API-Key"
+)
+
+func validScope(scope string) bool {
+	switch scope {
+	case scopeTasksRead, scopeTasksWrite, scopeTasksAdmin, scopeKeysAdmin, scopeOperator:
+		return true
+	}
+	return false
+}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Subject    string
+	Email      string
+	Tenant     string
+	Scopes     []string
+	Roles      []string
+	AuthMethod string
+}
+
+// HasScope reports whether the identity was granted scope, tasks:admin implies write and write implies read
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+		switch {
+		case s == scopeTasksAdmin && (scope == scopeTasksWrite || scope == scopeTasksRead):
+			return true
+		case s == scopeTasksWrite && scope == scopeTasksRead:
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+func identityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// writeRoute reports whether path changes tasks whatever the method, so its scope cannot be
+// lowered by sending it as a GET
+func writeRoute(path string) bool {
+	for _, route := range []string{"/tasks/add", "/tasks/complete", "/tasks/edit", "/tasks/delete"} {
+		if strings.HasSuffix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredScope returns the scope a request needs
+func requiredScope(req *http.Request) string {
+	path := req.URL.Path
+	switch {
+	case path == "/admin/keys" || strings.HasPrefix(path, "/admin/keys/"):
+		return scopeKeysAdmin
+	case strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/") || path == "/metrics":
+		return scopeOperator
+	case req.Method == "DELETE" && (path == "/tasks" || strings.HasPrefix(path, "/lists/") && strings.HasSuffix(path, "/tasks")):
+		return scopeTasksAdmin
+	case writeRoute(path):
+		return scopeTasksWrite
+	case req.Method == "GET" || req.Method == "HEAD":
+		return scopeTasksRead
+	}
+	return scopeTasksWrite
+}
+
+// Authenticator checks the credentials of every request before it reaches the task handlers,
+// API keys and verified client certificates are always accepted and JWT bearer tokens when a verifier is configured
+type Authenticator struct {
+	keys     *KeyStore
+	jwt      *JWTVerifier
+	required bool
+}
+
+func NewAuthenticator(keys *KeyStore, jwt *JWTVerifier, required bool) *Authenticator {
+	return &Authenticator{keys: keys, jwt: jwt, required: required}
+}
+
+// authenticate turns raw credentials into an identity
+func (a *Authenticator) authenticate(raw string) (*Identity, error) {
+	if strings.HasPrefix(raw, apiKeyPrefix) || a.jwt == nil {
+		key, err := a.keys.Verify(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &Identity{Subject: "key:" + key.Id, Tenant: key.Tenant, Scopes: key.Scopes, AuthMethod: "api_key"}, nil
+	}
+	return a.jwt.Verify(raw)
+}
+
+// credentials returns the API key sent in X-API-Key or the bearer token from Authorization
+func credentials(req *http.Request) string {
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return ""
+}
+
+func (a *Authenticator) deny(res http.ResponseWriter, status int, reason string, msg string) {
+	metrics.Count("auth_failures.count", 1, withGlobalTags("reason:"+reason))
+	if status == http.StatusUnauthorized {
+		res.Header().Set("WWW-Authenticate", `Bearer realm="task-manager"`)
+	}
+	http.Error(res, msg, status)
+}
+
+// Middleware authenticates the request, checks its scope and attaches the caller's identity to the context
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var identity *Identity
+		if raw := credentials(req); raw != "" {
+			var err error
+			identity, err = a.authenticate(raw)
+			if err != nil {
+				loggerFromContext(req.Context()).WithFields(log.Fields{"path": req.URL.Path, "error": err.Error()}).Warn("Rejected request with invalid credentials")
+				a.deny(res, http.StatusUnauthorized, "invalid", "invalid credentials: "+err.Error())
+				return
+			}
+		} else if certID, ok := certIdentity(req); ok {
+			identity = certID
+		} else if !a.required {
+			next.ServeHTTP(res, req)
+			return
+		} else {
+			a.deny(res, http.StatusUnauthorized, "missing", "missing credentials")
+			return
+		}
+		addLogFields(req.Context(), log.Fields{"user": identity.Subject, "tenant": identity.Tenant, "auth_method": identity.AuthMethod})
+		setMetricTenant(req.Context(), identity.Tenant)
+		if scope := requiredScope(req); !identity.HasScope(scope) {
+			loggerFromContext(req.Context()).WithField("scope", scope).Warn("Rejected request lacking scope")
+			a.deny(res, http.StatusForbidden, "scope", "credentials lack scope "+scope)
+			return
+		}
+		if req.Method != "GET" && req.Method != "HEAD" {
+			loggerFromContext(req.Context()).WithFields(log.Fields{"method": req.Method, "path": req.URL.Path}).Info("Audit")
+		}
+		ctx := context.WithValue(req.Context(), identityKey{}, identity)
+		next.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
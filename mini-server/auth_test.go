@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminKey = "tm_admin_c2VjcmV0"
+
+func newTestAPI(t *testing.T, path string) (*KeyStore, http.Handler) {
+	keys, err := LoadKeyStore(path)
+	assert.Nil(t, err)
+	assert.Nil(t, keys.Add(testAdminKey, defaultTenantName, []string{scopeTasksAdmin, scopeKeysAdmin, scopeOperator}))
+	auth := NewAuthenticator(keys, nil, true)
+	mux := http.NewServeMux()
+	tenants := NewTenantStore(TenantConfig{AllowUnlisted: true})
+	mux.Handle("/tasks", auth.Middleware(tenants))
+	mux.Handle("/tasks/", auth.Middleware(tenants))
+	mux.Handle("/lists/", auth.Middleware(tenants))
+	mux.Handle("/admin/keys", auth.Middleware(http.HandlerFunc(keys.KeysHandler)))
+	mux.Handle("/admin/keys/", auth.Middleware(http.HandlerFunc(keys.KeyHandler)))
+	return keys, mux
+}
+
+func doRequest(handler http.Handler, method, url, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func mintKey(t *testing.T, handler http.Handler, tenant string, scopes ...string) MintedAPIKey {
+	body, _ := json.Marshal(NewAPIKey{Tenant: tenant, Scopes: scopes})
+	w := doRequest(handler, http.MethodPost, "/admin/keys", testAdminKey, string(body))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var minted MintedAPIKey
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&minted))
+	return minted
+}
+
+func TestRequestsWithoutValidKeyRejected(t *testing.T) {
+	_, api := newTestAPI(t, "")
+	cases := []struct {
+		key      string
+		respCode int
+	}{
+		{"", http.StatusUnauthorized},
+		{"tm_admin_wrong", http.StatusUnauthorized},
+		{"tm_unknown_c2VjcmV0", http.StatusUnauthorized},
+		{"not-a-key", http.StatusUnauthorized},
+		{testAdminKey, http.StatusNoContent},
+	}
+
+	for _, c := range cases {
+		w := doRequest(api, http.MethodDelete, "/tasks", c.key, "")
+		assert.Equal(t, c.respCode, w.Code)
+	}
+}
+
+func TestKeyScopes(t *testing.T) {
+	_, api := newTestAPI(t, "")
+	reader := mintKey(t, api, "teamA", scopeTasksRead)
+	writer := mintKey(t, api, "teamA", scopeTasksWrite)
+	cases := []struct {
+		key, method, url, body string
+		respCode               int
+	}{
+		{reader.Key, http.MethodGet, "/tasks?showCompleted=true", "", http.StatusOK},
+		{reader.Key, http.MethodPost, "/tasks/add", `{"id": 1}`, http.StatusForbidden},
+		{writer.Key, http.MethodPost, "/tasks/add", `{"id": 1}`, http.StatusCreated},
+		{writer.Key, http.MethodGet, "/tasks?showCompleted=true", "", http.StatusOK},
+		{writer.Key, http.MethodDelete, "/tasks", "", http.StatusForbidden},
+		{writer.Key, http.MethodGet, "/admin/keys", "", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		w := doRequest(api, c.method, c.url, c.key, c.body)
+		assert.Equal(t, c.respCode, w.Code, "%s %s", c.method, c.url)
+	}
+}
+
+func TestReadOnlyKeyCannotWriteThroughAnyMethod(t *testing.T) {
+	_, api := newTestAPI(t, "")
+	reader := mintKey(t, api, defaultTenantName, scopeTasksRead)
+	writer := mintKey(t, api, defaultTenantName, scopeTasksWrite)
+	body := `{"id": 1, "title": "sneaky", "completed": true}`
+	for _, route := range []string{"/tasks/add", "/tasks/complete", "/tasks/edit", "/tasks/delete", "/lists/default/tasks/add", "/lists/default/tasks/delete"} {
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			w := doRequest(api, method, route, reader.Key, body)
+			assert.Equal(t, http.StatusForbidden, w.Code, method+" "+route)
+		}
+	}
+
+	// a key that may write still has to use the route's method
+	w := doRequest(api, http.MethodGet, "/tasks/add", writer.Key, body)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+	w = doRequest(api, http.MethodPost, "/tasks/delete", writer.Key, `{"id": 1}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = doRequest(api, http.MethodGet, "/tasks?showCompleted=true", reader.Key, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "sneaky")
+}
+
+func TestKeyIsBoundToItsTenant(t *testing.T) {
+	_, api := newTestAPI(t, "")
+	teamA := mintKey(t, api, "teamA", scopeTasksWrite)
+	w := doRequest(api, http.MethodPost, "/tasks/add", teamA.Key, `{"id": 1, "title": "secret"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// the admin key belongs to the default tenant and cannot see teamA's task
+	w = doRequest(api, http.MethodGet, "/tasks?showCompleted=true", testAdminKey, "")
+	assert.Equal(t, "Getting all tasks...\nThere are no tasks!", w.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks?showCompleted=true", nil)
+	req.Header.Set(apiKeyHeader, testAdminKey)
+	req.Header.Set(tenantHeader, "teamA")
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestVerifyWhileRevoking(t *testing.T) {
+	keys, _ := LoadKeyStore("")
+	minted, err := keys.Mint(defaultTenantName, []string{scopeTasksRead})
+	assert.Nil(t, err)
+	started, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		close(started)
+		for i := 0; i < 1000; i++ {
+			keys.Verify(minted.Key)
+		}
+	}()
+	<-started
+	_, err = keys.Revoke(minted.Id, "")
+	assert.Nil(t, err)
+	<-done
+	_, err = keys.Verify(minted.Key)
+	assert.Equal(t, errInvalidKey, err)
+}
+
+func TestRevokeKeyAndPersistHashesOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	_, api := newTestAPI(t, path)
+	minted := mintKey(t, api, "teamA", scopeTasksRead)
+	assert.True(t, strings.HasPrefix(minted.Key, apiKeyPrefix+minted.Id+"_"))
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	_, secret, _ := splitKey(minted.Key)
+	assert.NotContains(t, string(data), secret)
+	assert.Contains(t, string(data), hashSecret(secret))
+	assert.NotContains(t, string(data), `"id": "admin"`)
+
+	w := doRequest(api, http.MethodGet, "/tasks?showCompleted=true", minted.Key, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(api, http.MethodDelete, "/admin/keys/"+minted.Id, testAdminKey, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = doRequest(api, http.MethodGet, "/tasks?showCompleted=true", minted.Key, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// keys minted before a restart still load, and the revocation sticks
+	reloaded, err := LoadKeyStore(path)
+	assert.Nil(t, err)
+	_, err = reloaded.Verify(minted.Key)
+	assert.Equal(t, errInvalidKey, err)
+}
+
+func TestKeyAdminIsBoundToItsTenant(t *testing.T) {
+	_, api := newTestAPI(t, "")
+	adminA := mintKey(t, api, "teamA", scopeKeysAdmin)
+	readerA := mintKey(t, api, "teamA", scopeTasksRead)
+	readerB := mintKey(t, api, "teamB", scopeTasksRead)
+
+	// teamA's admin only lists teamA's keys
+	w := doRequest(api, http.MethodGet, "/admin/keys", adminA.Key, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed []APIKey
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&listed))
+	ids := make([]string, 0, len(listed))
+	for _, k := range listed {
+		assert.Equal(t, "teamA", k.Tenant)
+		ids = append(ids, k.Id)
+	}
+	assert.ElementsMatch(t, []string{adminA.Id, readerA.Id}, ids)
+
+	// it mints keys for teamA, by default too, but not for teamB nor operator keys
+	cases := []struct {
+		body     string
+		respCode int
+	}{
+		{`{"tenant": "teamA", "scopes": ["keys:admin"]}`, http.StatusCreated},
+		{`{"scopes": ["tasks:read"]}`, http.StatusCreated},
+		{`{"tenant": "teamB", "scopes": ["tasks:read"]}`, http.StatusForbidden},
+		{`{"tenant": "teamB", "scopes": ["keys:admin"]}`, http.StatusForbidden},
+		{`{"tenant": "teamA", "scopes": ["server:admin"]}`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		w := doRequest(api, http.MethodPost, "/admin/keys", adminA.Key, c.body)
+		assert.Equal(t, c.respCode, w.Code, c.body)
+		if w.Code == http.StatusCreated {
+			var minted MintedAPIKey
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(&minted))
+			assert.Equal(t, "teamA", minted.Tenant)
+		}
+	}
+
+	// teamB's key cannot be revoked by teamA, and is still valid
+	w = doRequest(api, http.MethodDelete, "/admin/keys/"+readerB.Id, adminA.Key, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, http.StatusOK, doRequest(api, http.MethodGet, "/tasks?showCompleted=true", readerB.Key, "").Code)
+	w = doRequest(api, http.MethodDelete, "/admin/keys/"+readerA.Id, adminA.Key, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// the operator sees and revokes every tenant's keys
+	w = doRequest(api, http.MethodGet, "/admin/keys", testAdminKey, "")
+	assert.Contains(t, w.Body.String(), readerB.Id)
+	w = doRequest(api, http.MethodDelete, "/admin/keys/"+readerB.Id, testAdminKey, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestAdminRoutesNeedOperatorScope(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/admin/log-level", nil)
+	assert.Equal(t, scopeOperator, requiredScope(req))
+	req = httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	assert.Equal(t, scopeOperator, requiredScope(req))
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	assert.Equal(t, scopeOperator, requiredScope(req))
+	req = httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+	assert.Equal(t, scopeKeysAdmin, requiredScope(req))
+	req = httptest.NewRequest(http.MethodDelete, "/admin/keys/abc", nil)
+	assert.Equal(t, scopeKeysAdmin, requiredScope(req))
+}
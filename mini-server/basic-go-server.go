@@ -1,24 +1,33 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/DataDog/datadog-go/statsd"
 	log "github.com/sirupsen/logrus"
-	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
-	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
 )
 
 type Task struct {
-	Id          int64  `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Completed   bool   `json:"completed"`
+	Id          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Completed   bool     `json:"completed"`
+	Owner       string   `json:"owner,omitempty"`
+	Assignees   []string `json:"assignees,omitempty"`
 }
 
 type UpdateTask struct {
@@ -26,29 +35,128 @@ type UpdateTask struct {
 	Completed bool  `json:"completed"`
 }
 
+// EditTask changes the fields that are set and leaves the rest alone, Completed false reopens a task
+type EditTask struct {
+	Id          int64     `json:"id"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Assignees   *[]string `json:"assignees"`
+	Completed   *bool     `json:"completed"`
+}
+
 type TaskList struct {
-	tasks       []Task
-	numTasks    int
-	numComplete int
+	mu     sync.Mutex
+	name   string
+	tenant string
+	store  *ListStore
+	tasks  []Task
 }
 
-var client *statsd.Client
+var metrics Metrics = NoopMetrics{}
+var tracing Tracer = noopTracer{}
 var standardFields log.Fields
+var globalTags = []string{"environment:dev"}
+var policies = &PolicyStore{policy: defaultPolicy}
+
+// withGlobalTags returns the tags sent with every metric followed by tags
+func withGlobalTags(tags ...string) []string {
+	return append(append([]string{}, globalTags...), tags...)
+}
 
 func getTaskAsString(task Task, res http.ResponseWriter) {
 	fmt.Fprintf(res, "Task:\n\tId = %d\n\tTitle = %s\n\tDescription = %s\n\tCompleted = %t", task.Id, task.Title, task.Description, task.Completed)
+	if task.Owner != "" {
+		fmt.Fprintf(res, "\n\tOwner = %s", task.Owner)
+	}
+	if len(task.Assignees) > 0 {
+		fmt.Fprintf(res, "\n\tAssignees = %s", strings.Join(task.Assignees, ", "))
+	}
+}
+
+// wantsJSON reports whether the caller asked for JSON rather than the plain text responses
+func wantsJSON(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+// listName returns the list's name, treating an unnamed list as the default one
+func (t *TaskList) listName() string {
+	if t.name == "" {
+		return defaultListName
+	}
+	return t.name
+}
+
+func (t *TaskList) tenantName() string {
+	if t.tenant == "" {
+		return defaultTenantName
+	}
+	return t.tenant
+}
+
+// metricTags returns the tags sent with every gauge for this list
+func (t *TaskList) metricTags() []string {
+	return withGlobalTags("list:"+t.listName(), "tenant:"+t.tenantName())
+}
+
+// reportGauges sends the task gauges computed from the list's tasks, callers must hold t.mu
+func (t *TaskList) reportGauges() {
+	numComplete := 0
+	for _, task := range t.tasks {
+		if task.Completed {
+			numComplete++
+		}
+	}
+	metrics.Gauge("num_total_tasks.gauge", float64(len(t.tasks)), t.metricTags())
+	metrics.Gauge("num_complete_tasks.gauge", float64(numComplete), t.metricTags())
+	metrics.Gauge("num_incomplete_tasks.gauge", float64(len(t.tasks)-numComplete), t.metricTags())
+}
+
+// startSpan starts a span around a store operation on this list
+func (t *TaskList) startSpan(req *http.Request, op string) Span {
+	_, span := startSpan(req.Context(), "store."+op)
+	span.SetTag("list", t.listName())
+	span.SetTag("tenant", t.tenantName())
+	return span
+}
+
+// logger returns the request's logger with the list and tenant this list belongs to
+func (t *TaskList) logger(req *http.Request) *log.Entry {
+	return loggerFromContext(req.Context()).WithFields(log.Fields{"list": t.listName(), "tenant": t.tenantName()})
 }
 
 // handler to deal with all /tasks routes (so far: Get all tasks and clear all tasks)
 func (t *TaskList) TasksHandler(res http.ResponseWriter, req *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	switch req.Method {
 	case "GET":
-		showCompleted := req.URL.Query().Get("showCompleted")
-		showCompletedBool, err := strconv.ParseBool(showCompleted)
-		if err != nil {
-			log.Panic(err)
+		// completed tasks are hidden unless asked for, JSON callers see them unless they opt out
+		showCompletedBool := wantsJSON(req)
+		if showCompleted := req.URL.Query().Get("showCompleted"); showCompleted != "" {
+			b, err := strconv.ParseBool(showCompleted)
+			if err != nil {
+				http.Error(res, fmt.Sprintf("invalid showCompleted %q", showCompleted), http.StatusBadRequest)
+				return
+			}
+			showCompletedBool = b
 		}
-		if len(t.tasks) == 0 {
+		span := t.startSpan(req, "list")
+		defer span.Finish()
+		if wantsJSON(req) {
+			tasks := []Task{}
+			for _, task := range t.tasks {
+				if allowed, _ := policies.Allowed(req, actionView, task); allowed && (showCompletedBool || !task.Completed) {
+					tasks = append(tasks, task)
+				}
+			}
+			writeJSON(res, http.StatusOK, tasks)
+		} else if len(t.tasks) == 0 {
 			res.WriteHeader(http.StatusOK)
 			fmt.Fprint(res, "Getting all tasks...\n")
 			fmt.Fprint(res, "There are no tasks!")
@@ -57,10 +165,12 @@ func (t *TaskList) TasksHandler(res http.ResponseWriter, req *http.Request) {
 			res.WriteHeader(http.StatusOK)
 			fmt.Fprint(res, "Getting all tasks...\n")
 
-			info := fmt.Sprintf("User requested %d tasks", len(t.tasks))
-			log.WithFields(standardFields).Info(info)
+			t.logger(req).WithField("count", len(t.tasks)).Info("User requested tasks")
 
 			for _, task := range t.tasks {
+				if allowed, _ := policies.Allowed(req, actionView, task); !allowed {
+					continue
+				}
 				if showCompletedBool || !task.Completed {
 					getTaskAsString(task, res)
 					fmt.Fprint(res, "\n")
@@ -69,48 +179,101 @@ func (t *TaskList) TasksHandler(res http.ResponseWriter, req *http.Request) {
 
 		}
 	case "DELETE":
+		span := t.startSpan(req, "clear")
+		defer span.Finish()
+		for _, task := range t.tasks {
+			if allowed, reason := policies.Allowed(req, actionDelete, task); !allowed {
+				http.Error(res, reason, http.StatusForbidden)
+				return
+			}
+		}
+		if t.store != nil {
+			t.store.releaseTasks(len(t.tasks))
+		}
 		t.tasks = t.tasks[:0]
 		res.WriteHeader(http.StatusNoContent)
 		//send metrics
-		client.Gauge("num_total_tasks.gauge", 0.0, []string{"environment:dev"}, 1)
-		client.Gauge("num_complete_tasks.gauge", 0.0, []string{"environment:dev"}, 1)
-		client.Gauge("num_incomplete_tasks.gauge", 0.0, []string{"environment:dev"}, 1)
+		t.reportGauges()
 	}
 }
 
+// allowMethod answers 405 unless req uses method, the write routes skip the audit log and the
+// idempotency cache for any other
+func allowMethod(res http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method {
+		return true
+	}
+	res.Header().Set("Allow", method)
+	http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
 func (t *TaskList) AddTaskHandler(res http.ResponseWriter, req *http.Request) {
+	if !allowMethod(res, req, http.MethodPost) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	var task Task
 	err := json.NewDecoder(req.Body).Decode(&task)
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
-	res.WriteHeader(http.StatusCreated)
+	span := t.startSpan(req, "add")
+	defer span.Finish()
+	span.SetTag("task.id", task.Id)
+	if t.store != nil && !t.store.reserveTask() {
+		http.Error(res, fmt.Sprintf("Task quota of %d reached for tenant %s", t.store.maxTasks, t.tenantName()), http.StatusForbidden)
+		return
+	}
+	if identity, ok := identityFromContext(req.Context()); ok {
+		task.Owner = identity.Subject
+	}
 	t.tasks = append(t.tasks, task)
-	fmt.Fprint(res, "Adding the following task to your task list\n")
-	getTaskAsString(task, res)
+	if wantsJSON(req) {
+		writeJSON(res, http.StatusCreated, task)
+	} else {
+		res.WriteHeader(http.StatusCreated)
+		fmt.Fprint(res, "Adding the following task to your task list\n")
+		getTaskAsString(task, res)
+	}
 
-	info := fmt.Sprintf("Added task with Id = %d, Title = %s, Description = %s\n", task.Id, task.Title, task.Description)
-	log.WithFields(standardFields).Info(info)
+	t.logger(req).WithFields(log.Fields{"task_id": task.Id, "title": task.Title, "description": task.Description}).Info("Added task")
 
 	//send metrics
-	t.numTasks += 1
-	numIncomplete := t.numTasks - t.numComplete
-	client.Gauge("num_total_tasks.gauge", float64(t.numTasks), []string{"environment:dev"}, 1)
-	client.Gauge("num_complete_tasks.gauge", float64(t.numComplete), []string{"environment:dev"}, 1)
-	client.Gauge("num_incomplete_tasks.gauge", float64(numIncomplete), []string{"environment:dev"}, 1)
-
+	t.reportGauges()
 }
 
 func (t *TaskList) CompleteTaskHandler(res http.ResponseWriter, req *http.Request) {
+	if !allowMethod(res, req, http.MethodPatch) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	var update UpdateTask
 	err := json.NewDecoder(req.Body).Decode(&update)
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
-	res.WriteHeader(http.StatusOK)
 	id := update.Id
+	span := t.startSpan(req, "complete")
+	defer span.Finish()
+	span.SetTag("task.id", id)
+	for _, task := range t.tasks {
+		if task.Id == id && !task.Completed {
+			if allowed, reason := policies.Allowed(req, actionComplete, task); !allowed {
+				http.Error(res, reason, http.StatusForbidden)
+				return
+			}
+		}
+	}
+	if wantsJSON(req) {
+		t.completeJSON(res, req, id)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
 	completedSomething := false
 	for i, task := range t.tasks {
 		if task.Id == id {
@@ -121,72 +284,368 @@ func (t *TaskList) CompleteTaskHandler(res http.ResponseWriter, req *http.Reques
 				t.tasks[i].Completed = true
 				completedSomething = true
 				fmt.Fprintf(res, "Completed task with id %d\n", id)
-				log.Printf("Completed task with id %d\n", id)
-				//send metrics
-				t.numComplete += 1
-				numIncomplete := t.numTasks - t.numComplete
-				client.Gauge("num_complete_tasks.gauge", float64(t.numComplete), []string{"environment:dev"}, 1)
-				client.Gauge("num_incomplete_tasks.gauge", float64(numIncomplete), []string{"environment:dev"}, 1)
+				t.logger(req).WithField("id", id).Info("Completed task")
 			}
 		}
 	}
 	if !completedSomething {
 		fmt.Fprintf(res, "No task with ID = %d to complete\n", id)
 	}
+	//send metrics
+	t.reportGauges()
 
 }
 
+// completeJSON completes a task for JSON callers, who get the task back or a 404 or 409, callers must hold t.mu
+func (t *TaskList) completeJSON(res http.ResponseWriter, req *http.Request, id int64) {
+	for i, task := range t.tasks {
+		if task.Id != id {
+			continue
+		}
+		if task.Completed {
+			http.Error(res, fmt.Sprintf("Task %d is already completed", id), http.StatusConflict)
+			return
+		}
+		t.tasks[i].Completed = true
+		t.logger(req).WithField("id", id).Info("Completed task")
+		t.reportGauges()
+		writeJSON(res, http.StatusOK, t.tasks[i])
+		return
+	}
+	http.Error(res, fmt.Sprintf("No task with ID = %d to complete", id), http.StatusNotFound)
+}
+
+// handler for /tasks/edit, changing the title, description, assignees or completion of a task
+func (t *TaskList) EditTaskHandler(res http.ResponseWriter, req *http.Request) {
+	if !allowMethod(res, req, http.MethodPatch) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var edit EditTask
+	err := json.NewDecoder(req.Body).Decode(&edit)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	span := t.startSpan(req, "edit")
+	defer span.Finish()
+	span.SetTag("task.id", edit.Id)
+	for i, task := range t.tasks {
+		if task.Id != edit.Id {
+			continue
+		}
+		if allowed, reason := policies.Allowed(req, actionEdit, task); !allowed {
+			http.Error(res, reason, http.StatusForbidden)
+			return
+		}
+		if edit.Completed != nil {
+			if allowed, reason := policies.Allowed(req, actionComplete, task); !allowed {
+				http.Error(res, reason, http.StatusForbidden)
+				return
+			}
+		}
+		if edit.Title != nil {
+			t.tasks[i].Title = *edit.Title
+		}
+		if edit.Description != nil {
+			t.tasks[i].Description = *edit.Description
+		}
+		if edit.Assignees != nil {
+			t.tasks[i].Assignees = *edit.Assignees
+		}
+		if edit.Completed != nil {
+			t.tasks[i].Completed = *edit.Completed
+			t.reportGauges()
+		}
+		if wantsJSON(req) {
+			writeJSON(res, http.StatusOK, t.tasks[i])
+		} else {
+			res.WriteHeader(http.StatusOK)
+			fmt.Fprint(res, "Updated the following task\n")
+			getTaskAsString(t.tasks[i], res)
+		}
+		t.logger(req).WithField("id", edit.Id).Info("Edited task")
+		return
+	}
+	http.Error(res, fmt.Sprintf("No task with ID = %d to edit", edit.Id), http.StatusNotFound)
+}
+
+// handler for /tasks/delete, removing a single task
+func (t *TaskList) DeleteTaskHandler(res http.ResponseWriter, req *http.Request) {
+	if !allowMethod(res, req, http.MethodDelete) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var update UpdateTask
+	err := json.NewDecoder(req.Body).Decode(&update)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	span := t.startSpan(req, "delete")
+	defer span.Finish()
+	span.SetTag("task.id", update.Id)
+	for i, task := range t.tasks {
+		if task.Id != update.Id {
+			continue
+		}
+		if allowed, reason := policies.Allowed(req, actionDelete, task); !allowed {
+			http.Error(res, reason, http.StatusForbidden)
+			return
+		}
+		t.tasks = append(t.tasks[:i], t.tasks[i+1:]...)
+		if t.store != nil {
+			t.store.releaseTasks(1)
+		}
+		res.WriteHeader(http.StatusNoContent)
+		t.logger(req).WithField("id", update.Id).Info("Deleted task")
+
+		//send metrics
+		t.reportGauges()
+		return
+	}
+	http.Error(res, fmt.Sprintf("No task with ID = %d to delete", update.Id), http.StatusNotFound)
+}
+
+// handler for /tasks/{id}, a single task, hidden like in the list when the caller may not view it
+func (t *TaskList) TaskHandler(res http.ResponseWriter, req *http.Request, rawID string) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("invalid task ID %q", rawID), http.StatusBadRequest)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := t.startSpan(req, "get")
+	defer span.Finish()
+	span.SetTag("task.id", id)
+	for _, task := range t.tasks {
+		if task.Id != id {
+			continue
+		}
+		if allowed, _ := policies.Allowed(req, actionView, task); !allowed {
+			break
+		}
+		if wantsJSON(req) {
+			writeJSON(res, http.StatusOK, task)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+		getTaskAsString(task, res)
+		fmt.Fprint(res, "\n")
+		return
+	}
+	http.Error(res, fmt.Sprintf("No task with ID = %d", id), http.StatusNotFound)
+}
+
 func (t *TaskList) MainPageHandler(res http.ResponseWriter, req *http.Request) {
 	res.WriteHeader(http.StatusOK)
 	fmt.Fprintf(res, "Welcome to your super simple task manager\n")
-	log.WithFields(standardFields).Info("Main page accessed")
+	t.logger(req).Info("Main page accessed")
 }
 
 func main() {
-	client, _ = statsd.New("")
+	config, printConfig, err := LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := config.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	globalTags = config.MetricTags()
 	log.SetOutput(os.Stdout)
-	log.SetFormatter(&log.JSONFormatter{})
+	// sampling and redaction happen before the JSON formatter so dropped or redacted values never reach stdout
+	logSample, _ := parseLogSample(config.LogSample)
+	log.SetFormatter(NewLogFilter(&log.JSONFormatter{}, config.LogRedact, logSample))
+	logLevel, _ := log.ParseLevel(config.LogLevel)
+	log.SetLevel(logLevel)
 
 	//configure standard log fields
 	standardFields = log.Fields{
-		"hostname": "Calebs Mac",
-		"appname":  "mini-golang-http-server",
-		"session":  "testing",
+		"hostname": config.Hostname,
+		"appname":  config.AppName,
+		"session":  config.Session,
+	}
+
+	// metrics go to DogStatsD, a Prometheus /metrics endpoint or nowhere
+	metrics, err = NewMetrics(config.Metrics, MetricsOptions{
+		StatsdAddr:   config.StatsdAddr,
+		OTLPEndpoint: config.OTLPEndpoint,
+		Service:      config.Service,
+		Env:          config.Env,
+	})
+	if err != nil {
+		log.WithFields(standardFields).WithError(err).Warn("Failed to set up metrics, continuing without them")
+		metrics = NoopMetrics{}
 	}
 
 	//example log with fields
 	log.WithFields(standardFields).WithFields(log.Fields{"string": "foo", "int": 1, "float": 1.1}).Info("My first ssl event from Golang")
 	log.WithFields(standardFields).Info("Server started")
 
-	// task list
-	var taskList TaskList
+	// tenants, each with its own task lists; the default list keeps serving the /tasks routes
+	tenants, err := LoadTenantStore(config.Tenants)
+	if err != nil {
+		log.WithFields(standardFields).Fatal(err)
+	}
+	go tenants.ReportGauges(config.GaugeInterval, make(chan struct{}))
+
+	// API keys, an operator key can be bootstrapped from the config to mint the rest
+	keys, err := LoadKeyStore(config.APIKeys)
+	if err != nil {
+		log.WithFields(standardFields).Fatal(err)
+	}
+	if config.BootstrapKey != "" {
+		keys.Add(config.BootstrapKey, defaultTenantName, []string{scopeTasksAdmin, scopeKeysAdmin, scopeOperator})
+	}
+	if !config.RequireAuth {
+		log.WithFields(standardFields).Warn("API key authentication is disabled")
+	}
+	var jwtVerifier *JWTVerifier
+	if config.JWKS != "" {
+		jwtVerifier, err = NewJWTVerifier(JWTConfig{JWKS: config.JWKS, Issuer: config.JWTIssuer, Audience: config.JWTAudience, Leeway: 30 * time.Second})
+		if err != nil {
+			log.WithFields(standardFields).Fatal(err)
+		}
+	}
+	auth := NewAuthenticator(keys, jwtVerifier, config.RequireAuth)
+
+	// access policy for tasks, reloadable with SIGHUP or POST /admin/policy/reload
+	policies, err = LoadPolicyStore(config.Policy)
+	if err != nil {
+		log.WithFields(standardFields).Fatal(err)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := policies.Reload(); err != nil {
+				log.WithFields(standardFields).WithError(err).Error("Failed to reload access policy")
+				continue
+			}
+			log.WithFields(standardFields).Info("Reloaded access policy")
+		}
+	}()
+	idempotency := NewIdempotencyCache()
+	go idempotency.Expire(time.Minute, make(chan struct{}))
+	api := auth.Middleware(idempotency.Middleware(tenants))
 
 	//congifure and set up apm and http routing and multiplexer
-	tracer.Start(
-		tracer.WithService("task-manager"),
-		tracer.WithEnv("dev"),
-	)
-	defer tracer.Stop()
-
-	err := profiler.Start(
-		profiler.WithService("task-manager"),
-		profiler.WithEnv("dev"),
-		profiler.WithProfileTypes(
-			profiler.CPUProfile,
-			profiler.HeapProfile,
-		),
-	)
+	// traces go to the Datadog agent or an OTLP collector, both accept W3C traceparent
+	tracing, err = NewTracer(config.Tracing, TracingOptions{Service: config.Service, Env: config.Env, OTLPEndpoint: config.OTLPEndpoint})
+	if err != nil {
+		log.WithFields(standardFields).WithError(err).Warn("Failed to set up tracing, continuing without it")
+		tracing = noopTracer{}
+	}
+
+	// continuous profiling is best effort, the server runs fine without an agent
+	err = StartProfiling(ProfilingOptions{Service: config.Service, Env: config.Env, Types: config.ProfileTypes, Datadog: config.Profiler})
 	if err != nil {
-		log.Panic(err)
+		log.WithFields(standardFields).WithError(err).Warn("Failed to start profiler, continuing without it")
+	}
+
+	// probes, registered outside of auth so the kubelet can reach them, on a plain HTTP port of
+	// their own when addr requires client certificates. Tasks live in memory so there is nothing
+	// to load, readiness waits on what authenticating a request needs: the JWKS and the certificate.
+	health := NewHealth()
+	if jwtVerifier != nil {
+		health.AddReadinessCheck("jwks", jwtVerifier.Ready)
 	}
-	defer profiler.Stop()
 
 	// Create a traced mux router
-	mux := httptrace.NewServeMux()
-	mux.HandleFunc("/", taskList.MainPageHandler)
-	mux.HandleFunc("/tasks", taskList.TasksHandler)
-	mux.HandleFunc("/tasks/add", taskList.AddTaskHandler)
-	mux.HandleFunc("/tasks/complete", taskList.CompleteTaskHandler)
-	http.ListenAndServe(":9000", mux)
+	mux := http.NewServeMux()
+	probes := mux
+	var probeServer *http.Server
+	if config.HealthAddr != "" {
+		probes = http.NewServeMux()
+		probeServer = &http.Server{Addr: config.HealthAddr, Handler: probes, ReadHeaderTimeout: 5 * time.Second}
+	}
+	probes.HandleFunc("/healthz", health.LivenessHandler)
+	probes.HandleFunc("/readyz", health.ReadinessHandler)
+	probes.HandleFunc("/startupz", health.StartupHandler)
+	// /metrics names every tenant, it is served on the probe port when there is one and to
+	// operators only otherwise
+	if prom, ok := metrics.(*telemetry.PrometheusMetrics); ok {
+		if probeServer != nil {
+			probes.Handle("/metrics", prom)
+		} else {
+			mux.Handle("/metrics", auth.Middleware(prom))
+		}
+	}
+	// every other route is traced, logged, counted and timed
+	handle := func(route string, handler http.Handler) {
+		mux.Handle(route, tracing.Middleware(route, requestLogging(route, instrument(route, handler))))
+	}
+	handle("/", api)
+	handle("/tasks", api)
+	handle("/tasks/add", api)
+	handle("/tasks/complete", api)
+	handle("/tasks/edit", api)
+	handle("/tasks/delete", api)
+	handle("/tasks/", api)
+	handle("/lists", api)
+	handle("/lists/", api)
+	handle("/admin/keys", auth.Middleware(http.HandlerFunc(keys.KeysHandler)))
+	handle("/admin/keys/", auth.Middleware(http.HandlerFunc(keys.KeyHandler)))
+	handle("/admin/policy/reload", auth.Middleware(http.HandlerFunc(policies.ReloadHandler)))
+	handle("/admin/log-level", auth.Middleware(http.HandlerFunc(LogLevelHandler)))
+	if config.Pprof {
+		handle("/debug/pprof/", auth.Middleware(pprofHandler()))
+	}
+	server := &http.Server{Addr: config.Addr, Handler: mux}
+	ln, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		log.WithFields(standardFields).Fatal(err)
+	}
+	listen := func() error { return server.Serve(ln) }
+	if config.TLSCert != "" {
+		certs, err := newCertReloader(TLSOptions{CertFile: config.TLSCert, KeyFile: config.TLSKey, ClientCAFile: config.TLSClientCA})
+		if err != nil {
+			log.WithFields(standardFields).Fatal(err)
+		}
+		go certs.watch(config.TLSReloadInterval, make(chan struct{}))
+		health.AddReadinessCheck("tls", certs.Ready)
+		server.TLSConfig = certs.TLSConfig()
+		listen = func() error { return server.ServeTLS(ln, "", "") }
+	}
+	if probeServer != nil {
+		probeLn, err := net.Listen("tcp", config.HealthAddr)
+		if err != nil {
+			log.WithFields(standardFields).Fatal(err)
+		}
+		// closed once the drain is over, readiness keeps failing while it lasts
+		defer probeServer.Close()
+		go probeServer.Serve(probeLn)
+	}
+	health.SetStarted()
 
+	// serve until SIGTERM or SIGINT, then drain requests and flush everything before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	err = serve(ctx, server, listen, config.ShutdownTimeout, health)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithFields(standardFields).WithError(err).Error("Server stopped with an error")
+	}
+	if err := keys.Flush(); err != nil {
+		log.WithFields(standardFields).WithError(err).Error("Failed to flush API keys")
+	}
+	metrics.Close()
+	StopProfiling()
+	tracing.Stop()
+	log.WithFields(standardFields).Info("Server stopped")
 }
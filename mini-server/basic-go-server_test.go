@@ -7,9 +7,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
 )
 
 func TestEmptyGetTasksHandlerShowCompleteTrue(t *testing.T) {
@@ -51,6 +55,8 @@ func TestEmptyGetTasksHandler(t *testing.T) {
 	}{
 		{"/tasks?", "showCompleted=false", "Getting all tasks...\nThere are no tasks!", http.StatusOK},
 		{"/tasks?", "showCompleted=true", "Getting all tasks...\nThere are no tasks!", http.StatusOK},
+		{"/tasks", "", "Getting all tasks...\nThere are no tasks!", http.StatusOK},
+		{"/tasks?", "showCompleted=maybe", "invalid showCompleted \"maybe\"\n", http.StatusBadRequest},
 	}
 
 	for _, c := range cases {
@@ -78,6 +84,7 @@ func TestAddCompleteGetTaskCorrect(t *testing.T) {
 		{"/tasks", "/add", "2", "secondTask", "boo2", "false", "Adding the following task to your task list\nTask:\n\tId = 2\n\tTitle = secondTask\n\tDescription = boo2\n\tCompleted = false", http.StatusCreated},
 		{"/tasks", "/complete", "2", "", "", "", "Completed task with id 2\n", http.StatusOK},
 		{"/tasks", "", "", "", "", "true", "Getting all tasks...\nTask:\n\tId = 2\n\tTitle = secondTask\n\tDescription = boo2\n\tCompleted = true\n", http.StatusOK},
+		{"/tasks", "", "", "", "", "", "Getting all tasks...\n", http.StatusOK},
 	}
 
 	for _, c := range cases {
@@ -298,3 +305,96 @@ func TestAddGetClearTasksCorrect(t *testing.T) {
 	}
 
 }
+
+func TestTaskGaugesFollowStore(t *testing.T) {
+	prom := telemetry.NewPrometheusMetrics()
+	metrics = prom
+	defer func() { metrics = NoopMetrics{} }()
+	var taskList TaskList
+	labels := `{environment="dev",list="default",tenant="default"}`
+	expectGauges := func(total, complete, incomplete int) {
+		body := scrape(prom)
+		assert.Contains(t, body, "num_total_tasks"+labels+" "+strconv.Itoa(total)+"\n")
+		assert.Contains(t, body, "num_complete_tasks"+labels+" "+strconv.Itoa(complete)+"\n")
+		assert.Contains(t, body, "num_incomplete_tasks"+labels+" "+strconv.Itoa(incomplete)+"\n")
+	}
+	add := func(task Task) {
+		body, _ := json.Marshal(task)
+		taskList.AddTaskHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/tasks/add", bytes.NewReader(body)))
+	}
+
+	add(Task{Id: 1, Title: "first"})
+	add(Task{Id: 2, Title: "already done", Completed: true})
+	expectGauges(2, 1, 1)
+
+	body, _ := json.Marshal(UpdateTask{Id: 1, Completed: true})
+	taskList.CompleteTaskHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPatch, "/tasks/complete", bytes.NewReader(body)))
+	expectGauges(2, 2, 0)
+
+	taskList.TasksHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/tasks", nil))
+	expectGauges(0, 0, 0)
+
+	add(Task{Id: 3, Title: "after clear"})
+	expectGauges(1, 0, 1)
+}
+
+func TestReportGaugesOnInterval(t *testing.T) {
+	prom := telemetry.NewPrometheusMetrics()
+	metrics = prom
+	defer func() { metrics = NoopMetrics{} }()
+	tenants := NewTenantStore(TenantConfig{AllowUnlisted: true})
+	tenant, _ := tenants.Get(defaultTenantName)
+	tenant.lists.Default().tasks = []Task{{Id: 1}, {Id: 2, Completed: true}}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go tenants.ReportGauges(10*time.Millisecond, stop)
+
+	labels := `{environment="dev",list="default",tenant="default"}`
+	assert.Eventually(t, func() bool {
+		return strings.Contains(scrape(prom), "num_total_tasks"+labels+" 2\n")
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, scrape(prom), "num_complete_tasks"+labels+" 1\n")
+}
+
+func TestJSONResponses(t *testing.T) {
+	var taskList TaskList
+	jsonRequest := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		switch url {
+		case "/tasks/add":
+			taskList.AddTaskHandler(w, req)
+		case "/tasks/complete":
+			taskList.CompleteTaskHandler(w, req)
+		case "/tasks/edit":
+			taskList.EditTaskHandler(w, req)
+		default:
+			taskList.TasksHandler(w, req)
+		}
+		return w
+	}
+
+	w := jsonRequest(http.MethodPost, "/tasks/add", `{"id":1,"title":"json"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"id":1,"title":"json","description":"","completed":false}`, w.Body.String())
+
+	w = jsonRequest(http.MethodPatch, "/tasks/complete", `{"id":1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":1,"title":"json","description":"","completed":true}`, w.Body.String())
+	assert.Equal(t, http.StatusConflict, jsonRequest(http.MethodPatch, "/tasks/complete", `{"id":1}`).Code)
+	assert.Equal(t, http.StatusNotFound, jsonRequest(http.MethodPatch, "/tasks/complete", `{"id":2}`).Code)
+
+	w = jsonRequest(http.MethodGet, "/tasks", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"id":1,"title":"json","description":"","completed":true}]`, w.Body.String())
+	w = jsonRequest(http.MethodGet, "/tasks?showCompleted=false", "")
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	// completed false through edit reopens the task
+	w = jsonRequest(http.MethodPatch, "/tasks/edit", `{"id":1,"completed":false}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":1,"title":"json","description":"","completed":false}`, w.Body.String())
+}
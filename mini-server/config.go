@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "TASK_MANAGER_"
+
+// Config is every setting of the server. Values come from the defaults, then the config file
+// (YAML or JSON), then TASK_MANAGER_* environment variables and finally command line flags.
+type Config struct {
+	Addr       string   `yaml:"addr"`
+	HealthAddr string   `yaml:"health-addr"`
+	Service    string   `yaml:"service"`
+	Env        string   `yaml:"env"`
+	Hostname   string   `yaml:"hostname"`
+	AppName    string   `yaml:"appname"`
+	Session    string   `yaml:"session"`
+	Tags       []string `yaml:"tags"`
+
+	LogLevel  string   `yaml:"log-level"`
+	LogSample []string `yaml:"log-sample"`
+	LogRedact []string `yaml:"log-redact"`
+
+	Metrics    string `yaml:"metrics"`
+	StatsdAddr string `yaml:"statsd-addr"`
+
+	GaugeInterval time.Duration `yaml:"gauge-interval"`
+
+	Tracing      string `yaml:"tracing"`
+	OTLPEndpoint string `yaml:"otlp-endpoint"`
+
+	Profiler     bool     `yaml:"profiler"`
+	ProfileTypes []string `yaml:"profile-types"`
+	Pprof        bool     `yaml:"pprof"`
+
+	Tenants string `yaml:"tenants"`
+	Policy  string `yaml:"policy"`
+
+	RequireAuth  bool   `yaml:"require-auth"`
+	APIKeys      string `yaml:"api-keys"`
+	BootstrapKey string `yaml:"bootstrap-key"`
+	JWKS         string `yaml:"jwks"`
+	JWTIssuer    string `yaml:"jwt-issuer"`
+	JWTAudience  string `yaml:"jwt-audience"`
+
+	TLSCert           string        `yaml:"tls-cert"`
+	TLSKey            string        `yaml:"tls-key"`
+	TLSClientCA       string        `yaml:"tls-client-ca"`
+	TLSReloadInterval time.Duration `yaml:"tls-reload-interval"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout"`
+}
+
+func defaultConfig() Config {
+	hostname, _ := os.Hostname()
+	return Config{
+		Addr:              ":9000",
+		Service:           "task-manager",
+		Env:               "dev",
+		Hostname:          hostname,
+		AppName:           "mini-golang-http-server",
+		Session:           "default",
+		LogLevel:          "info",
+		LogRedact:         []string{"title", "description"},
+		Metrics:           "statsd",
+		GaugeInterval:     10 * time.Second,
+		Tracing:           "datadog",
+		OTLPEndpoint:      "http://localhost:4318",
+		Profiler:          true,
+		ProfileTypes:      []string{"cpu", "heap"},
+		RequireAuth:       true,
+		TLSReloadInterval: 30 * time.Second,
+		ShutdownTimeout:   25 * time.Second,
+	}
+}
+
+// setting ties a config field to its flag and environment variable
+type setting struct {
+	name   string
+	usage  string
+	secret bool
+	value  flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"addr", "address to listen on", false, (*stringValue)(&c.Addr)},
+		{"health-addr", "plain HTTP address serving /healthz, /readyz, /startupz and /metrics, empty serves them on addr with /metrics for server:admin only", false, (*stringValue)(&c.HealthAddr)},
+		{"service", "service name reported to tracing and profiling", false, (*stringValue)(&c.Service)},
+		{"env", "environment reported in traces, profiles and metric tags", false, (*stringValue)(&c.Env)},
+		{"hostname", "hostname added to every log line", false, (*stringValue)(&c.Hostname)},
+		{"appname", "application name added to every log line", false, (*stringValue)(&c.AppName)},
+		{"session", "session added to every log line", false, (*stringValue)(&c.Session)},
+		{"tags", "comma separated extra tags sent with every metric", false, (*listValue)(&c.Tags)},
+		{"log-level", "minimum log level, can be changed at runtime on /admin/log-level", false, (*stringValue)(&c.LogLevel)},
+		{"log-sample", "comma separated message:N rules writing one in N of an info or debug message", false, (*listValue)(&c.LogSample)},
+		{"log-redact", "comma separated log fields whose values are replaced with [redacted]", false, (*listValue)(&c.LogRedact)},
+		{"metrics", "metrics backend: statsd, prometheus (served on /metrics), otlp or none", false, (*stringValue)(&c.Metrics)},
+		{"statsd-addr", "DogStatsD address, empty uses DD_AGENT_HOST", false, (*stringValue)(&c.StatsdAddr)},
+		{"gauge-interval", "how often the task gauges are re-sent", false, (*durationValue)(&c.GaugeInterval)},
+		{"tracing", "tracing backend: datadog, otel or none", false, (*stringValue)(&c.Tracing)},
+		{"otlp-endpoint", "OTLP/HTTP collector the otel tracer and otlp metrics export to", false, (*stringValue)(&c.OTLPEndpoint)},
+		{"profiler", "send continuous profiles to the Datadog agent", false, (*boolValue)(&c.Profiler)},
+		{"profile-types", "comma separated profiles to collect: cpu, heap, goroutine, mutex, block", false, (*listValue)(&c.ProfileTypes)},
+		{"pprof", "serve /debug/pprof, requires the server:admin scope", false, (*boolValue)(&c.Pprof)},
+		{"tenants", "path to a JSON file with tenant quotas", false, (*stringValue)(&c.Tenants)},
+		{"policy", "path to a JSON access policy for tasks, reloaded on SIGHUP", false, (*stringValue)(&c.Policy)},
+		{"require-auth", "reject requests without a valid API key, bearer token or client certificate", false, (*boolValue)(&c.RequireAuth)},
+		{"api-keys", "path to the JSON file API keys are stored in", false, (*stringValue)(&c.APIKeys)},
+		{"bootstrap-key", "operator API key of the form tm_<id>_<secret> used to mint the rest", true, (*stringValue)(&c.BootstrapKey)},
+		{"jwks", "JWKS file path or URL used to verify bearer tokens, empty disables JWT auth", false, (*stringValue)(&c.JWKS)},
+		{"jwt-issuer", "required iss claim of bearer tokens", false, (*stringValue)(&c.JWTIssuer)},
+		{"jwt-audience", "required aud claim of bearer tokens", false, (*stringValue)(&c.JWTAudience)},
+		{"tls-cert", "path to the TLS certificate, serves HTTPS when set", false, (*stringValue)(&c.TLSCert)},
+		{"tls-key", "path to the TLS private key", false, (*stringValue)(&c.TLSKey)},
+		{"tls-client-ca", "path to a CA bundle client certificates must be signed by, enables mTLS", false, (*stringValue)(&c.TLSClientCA)},
+		{"tls-reload-interval", "how often to check the TLS files for changes", false, (*durationValue)(&c.TLSReloadInterval)},
+		{"shutdown-timeout", "how long in-flight requests get to finish after SIGTERM", false, (*durationValue)(&c.ShutdownTimeout)},
+	}
+}
+
+func envName(setting string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// LoadConfig builds the config from args and the environment. It returns the config and whether
+// --print-config was passed.
+func LoadConfig(args []string, getenv func(string) string) (Config, bool, error) {
+	config := defaultConfig()
+
+	fs := flag.NewFlagSet("task-manager", flag.ContinueOnError)
+	path := fs.String("config", getenv(envPrefix+"CONFIG"), "path to a YAML or JSON config file (env "+envPrefix+"CONFIG)")
+	printConfig := fs.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	*path = configPath(args, *path)
+
+	if *path != "" {
+		data, err := os.ReadFile(*path)
+		if err != nil {
+			return config, false, fmt.Errorf("reading config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return config, false, fmt.Errorf("parsing config %s: %w", *path, err)
+		}
+	}
+
+	for _, s := range config.settings() {
+		if v := getenv(envName(s.name)); v != "" {
+			if err := s.value.Set(v); err != nil {
+				return config, false, fmt.Errorf("%s: %w", envName(s.name), err)
+			}
+		}
+		fs.Var(s.value, s.name, fmt.Sprintf("%s (env %s)", s.usage, envName(s.name)))
+	}
+	if err := fs.Parse(args); err != nil {
+		return config, false, err
+	}
+	return config, *printConfig, config.Validate()
+}
+
+// configPath finds -config in args, the file has to be read before the other flags are applied.
+// It parses args with every flag defined so the value of another flag is not taken for the end of
+// the flags, errors are left for the real parse to report.
+func configPath(args []string, fallback string) string {
+	fs := flag.NewFlagSet("task-manager", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("config", fallback, "")
+	fs.Bool("print-config", false, "")
+	scratch := defaultConfig()
+	for _, s := range scratch.settings() {
+		fs.Var(s.value, s.name, "")
+	}
+	fs.Parse(args)
+	return *path
+}
+
+// Validate checks the config for values the server cannot start with
+func (c Config) Validate() error {
+	var errs []string
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("addr %q is not host:port", c.Addr))
+	}
+	if c.HealthAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HealthAddr); err != nil {
+			errs = append(errs, fmt.Sprintf("health-addr %q is not host:port", c.HealthAddr))
+		} else if c.HealthAddr == c.Addr {
+			errs = append(errs, "health-addr must differ from addr")
+		}
+	}
+	if c.Service == "" {
+		errs = append(errs, "service must not be empty")
+	}
+	if c.Env == "" {
+		errs = append(errs, "env must not be empty")
+	}
+	for _, tag := range c.Tags {
+		if !strings.Contains(tag, ":") {
+			errs = append(errs, fmt.Sprintf("tag %q is not key:value", tag))
+		}
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("log-level %q is not a logrus level", c.LogLevel))
+	}
+	if _, err := parseLogSample(c.LogSample); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.GaugeInterval <= 0 {
+		errs = append(errs, "gauge-interval must be positive")
+	}
+	switch c.Metrics {
+	case "statsd", "prometheus", "otlp", "none":
+	default:
+		errs = append(errs, fmt.Sprintf("metrics %q must be statsd, prometheus, otlp or none", c.Metrics))
+	}
+	for _, t := range c.ProfileTypes {
+		if _, ok := profileTypes[t]; !ok {
+			errs = append(errs, fmt.Sprintf("profile type %q must be cpu, heap, goroutine, mutex or block", t))
+		}
+	}
+	switch c.Tracing {
+	case "datadog", "otel", "none":
+	default:
+		errs = append(errs, fmt.Sprintf("tracing %q must be datadog, otel or none", c.Tracing))
+	}
+	if c.BootstrapKey != "" {
+		if id, secret, ok := splitKey(c.BootstrapKey); !ok || id == "" || secret == "" {
+			errs = append(errs, "bootstrap-key must look like tm_<id>_<secret>")
+		}
+	}
+	if (c.JWTIssuer != "" || c.JWTAudience != "") && c.JWKS == "" {
+		errs = append(errs, "jwt-issuer and jwt-audience require jwks")
+	}
+	if c.JWKS != "" && (c.JWTIssuer == "" || c.JWTAudience == "") {
+		errs = append(errs, "jwks requires jwt-issuer and jwt-audience, or tokens the identity provider issued for any service would be accepted")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, "tls-cert and tls-key must be set together")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		errs = append(errs, "tls-client-ca requires tls-cert and tls-key")
+	}
+	if c.TLSClientCA != "" && c.HealthAddr == "" {
+		errs = append(errs, "tls-client-ca requires health-addr, probes cannot present a client certificate")
+	}
+	if c.TLSReloadInterval <= 0 {
+		errs = append(errs, "tls-reload-interval must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, "shutdown-timeout must be positive")
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// MetricTags returns the tags sent with every metric
+func (c Config) MetricTags() []string {
+	return append([]string{"environment:" + c.Env}, c.Tags...)
+}
+
+// Print writes the effective config as YAML, replacing secrets that are set with [redacted]
+func (c Config) Print(w io.Writer) error {
+	redacted := c
+	for _, s := range redacted.settings() {
+		if s.secret && s.value.String() != "" {
+			s.value.Set("[redacted]")
+		}
+	}
+	return yaml.NewEncoder(w).Encode(redacted)
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := parseBool(s)
+	*v = boolValue(b)
+	return err
+}
+func (v *boolValue) String() string   { return fmt.Sprint(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	*v = durationValue(d)
+	return err
+}
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "t", "true", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", s)
+}
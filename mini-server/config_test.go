@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("addr: \":8000\"\nenv: staging\nservice: from-file\ntags: [team:tasks]\ntls-reload-interval: 1m\n"), 0o600))
+
+	config, printConfig, err := LoadConfig([]string{"-config", path, "-service", "from-flag"}, env(map[string]string{
+		"TASK_MANAGER_ENV":     "prod",
+		"TASK_MANAGER_SERVICE": "from-env",
+	}))
+	assert.Nil(t, err)
+	assert.False(t, printConfig)
+	assert.Equal(t, ":8000", config.Addr)                      // file over default
+	assert.Equal(t, "prod", config.Env)                        // env over file
+	assert.Equal(t, "from-flag", config.Service)               // flag over env
+	assert.Equal(t, "mini-golang-http-server", config.AppName) // default
+	assert.Equal(t, time.Minute, config.TLSReloadInterval)
+	assert.Equal(t, []string{"environment:prod", "team:tasks"}, config.MetricTags())
+}
+
+func TestConfigFlagAfterOtherFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("env: staging\n"), 0o600))
+
+	config, _, err := LoadConfig([]string{"-service", "foo", "-pprof", "-config", path}, env(nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "staging", config.Env)
+	assert.Equal(t, "foo", config.Service)
+	assert.True(t, config.Pprof)
+}
+
+func TestConfigFromJSONFileViaEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"require-auth": false, "session": "load-test"}`), 0o600))
+
+	config, _, err := LoadConfig(nil, env(map[string]string{"TASK_MANAGER_CONFIG": path}))
+	assert.Nil(t, err)
+	assert.False(t, config.RequireAuth)
+	assert.Equal(t, "load-test", config.Session)
+}
+
+func TestConfigValidation(t *testing.T) {
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-addr", "9000"}, `invalid config: addr "9000" is not host:port`},
+		{[]string{"-tls-cert", "tls.crt"}, "invalid config: tls-cert and tls-key must be set together"},
+		{[]string{"-tls-client-ca", "ca.crt", "-health-addr", ":9001"}, "invalid config: tls-client-ca requires tls-cert and tls-key"},
+		{[]string{"-tls-cert", "tls.crt", "-tls-key", "tls.key", "-tls-client-ca", "ca.crt"}, "invalid config: tls-client-ca requires health-addr, probes cannot present a client certificate"},
+		{[]string{"-health-addr", ":9000"}, "invalid config: health-addr must differ from addr"},
+		{[]string{"-jwks", "jwks.json", "-jwt-issuer", "https://idp.example.com"}, "invalid config: jwks requires jwt-issuer and jwt-audience, or tokens the identity provider issued for any service would be accepted"},
+		{[]string{"-profile-types", "cpu,threads"}, `invalid config: profile type "threads" must be cpu, heap, goroutine, mutex or block`},
+		{[]string{"-log-level", "loud", "-log-sample", "Access"}, `invalid config: log-level "loud" is not a logrus level; log sample "Access" is not message:N`},
+		{[]string{"-metrics", "graphite"}, `invalid config: metrics "graphite" must be statsd, prometheus, otlp or none`},
+		{[]string{"-bootstrap-key", "hunter2", "-tags", "oops"}, `invalid config: tag "oops" is not key:value; bootstrap-key must look like tm_<id>_<secret>`},
+	}
+
+	for _, c := range cases {
+		_, _, err := LoadConfig(c.args, env(nil))
+		assert.EqualError(t, err, c.want)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("adr: \":8000\"\n"), 0o600))
+	_, _, err := LoadConfig([]string{"-config=" + path}, env(nil))
+	assert.ErrorContains(t, err, "field adr not found")
+}
+
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	config, printConfig, err := LoadConfig([]string{"-print-config"}, env(map[string]string{"TASK_MANAGER_BOOTSTRAP_KEY": "tm_admin_c2VjcmV0"}))
+	assert.Nil(t, err)
+	assert.True(t, printConfig)
+
+	var out bytes.Buffer
+	assert.Nil(t, config.Print(&out))
+	assert.Contains(t, out.String(), "bootstrap-key: '[redacted]'")
+	assert.NotContains(t, out.String(), "c2VjcmV0")
+	assert.Contains(t, out.String(), `addr: :9000`)
+	assert.Equal(t, "tm_admin_c2VjcmV0", config.BootstrapKey)
+}
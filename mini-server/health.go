@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// healthCheck is one named dependency check, a nil error means it passed
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// Health backs the /healthz, /readyz and /startupz probes
+type Health struct {
+	started  atomic.Bool
+	draining atomic.Bool
+
+	mu        sync.Mutex
+	readiness []healthCheck
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// SetStarted marks startup as finished, once everything is loaded and the listener is bound
+func (h *Health) SetStarted() {
+	h.started.Store(true)
+}
+
+// SetDraining makes readiness fail so no new traffic is routed here during shutdown
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+// AddReadinessCheck registers a dependency that must pass before the server is ready
+func (h *Health) AddReadinessCheck(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, healthCheck{name, check})
+}
+
+func (h *Health) startupCheck() error {
+	if !h.started.Load() {
+		return errors.New("server is still starting")
+	}
+	return nil
+}
+
+func (h *Health) drainingCheck() error {
+	if h.draining.Load() {
+		return errors.New("server is draining")
+	}
+	return nil
+}
+
+func report(res http.ResponseWriter, checks []healthCheck) {
+	r := healthReport{Status: "ok", Checks: make(map[string]checkResult)}
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			r.Status = "fail"
+			r.Checks[c.name] = checkResult{Status: "fail", Error: err.Error()}
+		} else {
+			r.Checks[c.name] = checkResult{Status: "ok"}
+		}
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	if r.Status != "ok" {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(res).Encode(r)
+}
+
+// handler for /healthz, the process is up and serving requests
+func (h *Health) LivenessHandler(res http.ResponseWriter, req *http.Request) {
+	report(res, []healthCheck{{"process", func() error { return nil }}})
+}
+
+// handler for /startupz
+func (h *Health) StartupHandler(res http.ResponseWriter, req *http.Request) {
+	report(res, []healthCheck{{"startup", h.startupCheck}})
+}
+
+// handler for /readyz, started, not draining and every registered dependency passing
+func (h *Health) ReadinessHandler(res http.ResponseWriter, req *http.Request) {
+	h.mu.Lock()
+	checks := append([]healthCheck{{"startup", h.startupCheck}, {"draining", h.drainingCheck}}, h.readiness...)
+	h.mu.Unlock()
+	report(res, checks)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(handler http.HandlerFunc) (int, healthReport) {
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest("GET", "/", nil))
+	var r healthReport
+	json.NewDecoder(res.Body).Decode(&r)
+	return res.Code, r
+}
+
+func TestLivenessAlwaysOk(t *testing.T) {
+	h := NewHealth()
+	code, r := probe(h.LivenessHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", r.Status)
+}
+
+func TestStartupFailsUntilStarted(t *testing.T) {
+	h := NewHealth()
+	code, r := probe(h.StartupHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", r.Checks["startup"].Status)
+
+	h.SetStarted()
+	code, _ = probe(h.StartupHandler)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestReadinessReportsEachCheck(t *testing.T) {
+	h := NewHealth()
+	h.SetStarted()
+	tlsErr := errors.New("TLS certificate expired")
+	h.AddReadinessCheck("tls", func() error { return tlsErr })
+	h.AddReadinessCheck("jwks", func() error { return nil })
+
+	code, r := probe(h.ReadinessHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", r.Status)
+	assert.Equal(t, checkResult{Status: "fail", Error: "TLS certificate expired"}, r.Checks["tls"])
+	assert.Equal(t, "ok", r.Checks["jwks"].Status)
+
+	tlsErr = nil
+	code, r = probe(h.ReadinessHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", r.Status)
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	h := NewHealth()
+	h.SetStarted()
+	h.SetDraining()
+	code, r := probe(h.ReadinessHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", r.Checks["draining"].Status)
+
+	// liveness keeps passing so the pod is not restarted mid-drain
+	code, _ = probe(h.LivenessHandler)
+	assert.Equal(t, http.StatusOK, code)
+}
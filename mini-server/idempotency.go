@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	idempotencyTTL       = 10 * time.Minute
+)
+
+type idempotentResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+	done    chan struct{} // closed once the first request has finished
+	ok      bool          // false if the first request failed with a 5xx and should not be replayed
+}
+
+// IdempotencyCache replays the response of a POST or PATCH carrying an Idempotency-Key, so a
+// client that retries after a timeout doesn't add the same task twice
+type IdempotencyCache struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+	now       func() time.Time
+}
+
+func NewIdempotencyCache() *IdempotencyCache {
+	return &IdempotencyCache{responses: make(map[string]*idempotentResponse), now: time.Now}
+}
+
+// capturingWriter writes the response through while keeping a copy to replay
+type capturingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// claim returns the cached response for key, or registers a new one the caller must fill in
+func (c *IdempotencyCache) claim(key string) (*idempotentResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.responses[key]; ok && (r.expires.IsZero() || !c.now().After(r.expires)) {
+		return r, true
+	}
+	r := &idempotentResponse{done: make(chan struct{})}
+	c.responses[key] = r
+	return r, false
+}
+
+// sweep drops the expired responses
+func (c *IdempotencyCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, r := range c.responses {
+		if !r.expires.IsZero() && now.After(r.expires) {
+			delete(c.responses, k)
+		}
+	}
+}
+
+// Expire sweeps the expired responses every interval until stop is closed
+func (c *IdempotencyCache) Expire(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.sweep()
+		}
+	}
+}
+
+func (c *IdempotencyCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.responses, key)
+}
+
+func (c *IdempotencyCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		idemKey := req.Header.Get(idempotencyKeyHeader)
+		if idemKey == "" || (req.Method != "POST" && req.Method != "PATCH") {
+			next.ServeHTTP(res, req)
+			return
+		}
+		// keys are per caller and route so one client can't replay another's response
+		caller := req.Header.Get(tenantHeader)
+		if identity, ok := identityFromContext(req.Context()); ok {
+			caller = identity.Subject + "/" + identity.Tenant
+		}
+		key := caller + " " + req.Method + " " + req.URL.Path + " " + idemKey
+
+		// a 5xx or a panic is not kept, the waiting retries claim the key again so exactly one of
+		// them runs the request and the others wait for it
+		cached, found := c.claim(key)
+		for found {
+			select {
+			case <-cached.done:
+			case <-req.Context().Done():
+				return
+			}
+			if cached.ok {
+				for k, v := range cached.header {
+					res.Header()[k] = v
+				}
+				res.Header().Set("Idempotent-Replayed", "true")
+				res.WriteHeader(cached.status)
+				res.Write(cached.body)
+				return
+			}
+			cached, found = c.claim(key)
+		}
+
+		w := &capturingWriter{ResponseWriter: res, status: http.StatusOK}
+		stored := false
+		defer func() {
+			if !stored {
+				c.forget(key)
+				close(cached.done)
+			}
+		}()
+		next.ServeHTTP(w, req)
+		if w.status >= 500 {
+			return
+		}
+		// the request ID belongs to each request, a replay keeps its own
+		header := res.Header().Clone()
+		header.Del(requestIDHeader)
+		c.mu.Lock()
+		cached.status, cached.header, cached.body = w.status, header, w.body.Bytes()
+		cached.expires = c.now().Add(idempotencyTTL)
+		cached.ok = true
+		c.mu.Unlock()
+		stored = true
+		close(cached.done)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKeyReplaysAdd(t *testing.T) {
+	var taskList TaskList
+	handler := NewIdempotencyCache().Middleware(http.HandlerFunc(taskList.AddTaskHandler))
+	add := func(key string, title string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/add", strings.NewReader(`{"id":1,"title":"`+title+`"}`))
+		req.Header.Set(idempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := add("k1", "retried")
+	second := add("k1", "retried")
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	assert.Len(t, taskList.tasks, 1)
+
+	add("k2", "another")
+	assert.Len(t, taskList.tasks, 2)
+}
+
+func TestIdempotencyKeyDoesNotReplayServerErrors(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyCache().Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(res, "boom", http.StatusServiceUnavailable)
+			return
+		}
+		res.WriteHeader(http.StatusCreated)
+	}))
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/add", nil)
+		req.Header.Set(idempotencyKeyHeader, "k")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyKeyRetriesAfterServerErrorRunOnce(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := NewIdempotencyCache().Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if first {
+			close(started)
+			<-release
+			http.Error(res, "boom", http.StatusServiceUnavailable)
+			return
+		}
+		res.WriteHeader(http.StatusCreated)
+	}))
+	add := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/add", nil)
+		req.Header.Set(idempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	first := make(chan int)
+	go func() { first <- add() }()
+	<-started
+	retries := make(chan int)
+	for i := 0; i < 5; i++ {
+		go func() { retries <- add() }()
+	}
+	// let the retries reach the wait before the first request fails
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, http.StatusServiceUnavailable, <-first)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusCreated, <-retries)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyKeyAfterPanicRunsAgain(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyCache().Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		res.WriteHeader(http.StatusCreated)
+	}))
+	add := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/add", nil)
+		req.Header.Set(idempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	assert.Panics(t, func() { add() })
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- add() }()
+	select {
+	case w := <-done:
+		assert.Equal(t, http.StatusCreated, w.Code)
+	case <-time.After(time.Second):
+		t.Fatal("the retry waited for a request that had panicked")
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyReplayKeepsItsRequestID(t *testing.T) {
+	cache := NewIdempotencyCache()
+	inner := cache.Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusCreated)
+	}))
+	for _, id := range []string{"first", "second"} {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/add", nil)
+		req.Header.Set(idempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		w.Header().Set(requestIDHeader, id)
+		inner.ServeHTTP(w, req)
+		assert.Equal(t, id, w.Header().Get(requestIDHeader))
+	}
+}
+
+func TestIdempotencySweepDropsExpiredResponses(t *testing.T) {
+	cache := NewIdempotencyCache()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	handler := cache.Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusCreated)
+	}))
+	for _, key := range []string{"old", "new"} {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/add", nil)
+		req.Header.Set(idempotencyKeyHeader, key)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		now = now.Add(idempotencyTTL / 2)
+	}
+	now = now.Add(time.Second)
+	cache.sweep()
+	assert.Len(t, cache.responses, 1)
+	for key := range cache.responses {
+		assert.True(t, strings.HasSuffix(key, " new"))
+	}
+}
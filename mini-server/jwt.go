@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	errUnknownKey       = errors.New("unknown signing key")
+	errInvalidSignature = errors.New("invalid token signature")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotYetValid = errors.New("token not yet valid")
+	errWrongIssuer      = errors.New("token issuer mismatch")
+	errWrongAudience    = errors.New("token audience mismatch")
+)
+
+// jwk is a single key from a JWKS document, only the RSA and P-256 EC fields are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC key is not on P-256")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// parseJWKS turns a JWKS document into public keys by kid, keys meant for encryption are skipped
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+// JWTConfig says where to find signing keys and which tokens to accept
+type JWTConfig struct {
+	JWKS     string // file path or http(s) URL
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// JWTVerifier validates RS256 and ES256 bearer tokens against a JWKS
+type JWTVerifier struct {
+	config      JWTConfig
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	lastRefresh time.Time // when the key set was last fetched, failed attempts included
+	refreshes   singleflight.Group
+	now         func() time.Time
+}
+
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("JWT verification needs an issuer and an audience")
+	}
+	v := &JWTVerifier{config: config, now: time.Now}
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) fetchJWKS() ([]byte, error) {
+	src := v.config.JWKS
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	c := http.Client{Timeout: 5 * time.Second}
+	resp, err := c.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// refresh reloads the key set. Concurrent calls share a single fetch, made without holding v.mu
+// so tokens signed with known keys are verified meanwhile.
+func (v *JWTVerifier) refresh() error {
+	_, err, _ := v.refreshes.Do("jwks", func() (interface{}, error) {
+		v.mu.Lock()
+		v.lastRefresh = v.now()
+		v.mu.Unlock()
+		data, err := v.fetchJWKS()
+		if err != nil {
+			return nil, fmt.Errorf("loading JWKS from %s: %w", v.config.JWKS, err)
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+		v.mu.Lock()
+		v.keys = keys
+		v.mu.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+// Ready fails while the key set holds no signing keys, no token could be verified
+func (v *JWTVerifier) Ready() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.keys) == 0 {
+		return errors.New("JWKS has no signing keys")
+	}
+	return nil
+}
+
+// lookup returns the signing key kid and whether the key set is due a reload
+func (v *JWTVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if pub, ok := v.keys[kid]; ok {
+		return pub, false
+	}
+	return nil, v.now().Sub(v.lastRefresh) > time.Minute
+}
+
+// key looks up a signing key, reloading the key set at most once a minute when the kid is
+// unknown, whether the last reload worked or not
+func (v *JWTVerifier) key(kid string) (crypto.PublicKey, error) {
+	pub, stale := v.lookup(kid)
+	if pub != nil {
+		return pub, nil
+	}
+	if stale && v.refresh() == nil {
+		if pub, _ := v.lookup(kid); pub != nil {
+			return pub, nil
+		}
+	}
+	return nil, errUnknownKey
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// audience accepts both the string and array forms of the aud claim
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	Email     string   `json:"email"`
+	Tenant    string   `json:"tenant"`
+	Roles     []string `json:"roles"`
+	Scope     string   `json:"scope"`
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errMalformedToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errMalformedToken
+	}
+	return nil
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], sig) != nil {
+			return errInvalidSignature
+		}
+	case "ES256":
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return errInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errInvalidSignature
+		}
+	default:
+		return errUnsupportedAlg
+	}
+	return nil
+}
+
+// Verify checks a token's signature, expiry, issuer and audience and maps its claims to an identity
+func (v *JWTVerifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return nil, errUnsupportedAlg
+	}
+	pub, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	if err := verifySignature(header.Alg, pub, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := v.now()
+	if claims.ExpiresAt == nil || now.After(time.Unix(*claims.ExpiresAt, 0).Add(v.config.Leeway)) {
+		return nil, errTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(v.config.Leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return nil, errTokenNotYetValid
+	}
+	if claims.Issuer != v.config.Issuer {
+		return nil, errWrongIssuer
+	}
+	if !claims.Audience.contains(v.config.Audience) {
+		return nil, errWrongAudience
+	}
+	return claims.identity(), nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// identity maps token claims to the caller, users get tasks:write unless the token carries
+// explicit scopes and the admin role grants tasks:admin
+func (c jwtClaims) identity() *Identity {
+	identity := &Identity{
+		Subject:    "user:" + c.Subject,
+		Email:      c.Email,
+		Tenant:     c.Tenant,
+		Roles:      c.Roles,
+		AuthMethod: "jwt",
+	}
+	if identity.Tenant == "" {
+		identity.Tenant = defaultTenantName
+	}
+	if c.Scope != "" {
+		identity.Scopes = strings.Fields(c.Scope)
+	} else {
+		identity.Scopes = []string{scopeTasksWrite}
+	}
+	for _, role := range c.Roles {
+		if role == "admin" {
+			identity.Scopes = append(identity.Scopes, scopeTasksAdmin)
+		}
+	}
+	return identity
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testSigner struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return &testSigner{rsaKey: rsaKey, ecKey: ecKey}
+}
+
+// writeJWKS publishes the signer's public keys to a JWKS file the way an identity provider would
+func (s *testSigner) writeJWKS(t *testing.T) string {
+	pad := func(b []byte) []byte { return append(make([]byte, 32-len(b)), b...) }
+	set := jwks{Keys: []jwk{
+		{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: b64.EncodeToString(s.rsaKey.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(s.rsaKey.E)).Bytes())},
+		{Kty: "EC", Kid: "ec-1", Use: "sig", Crv: "P-256", X: b64.EncodeToString(pad(s.ecKey.X.Bytes())), Y: b64.EncodeToString(pad(s.ecKey.Y.Bytes()))},
+	}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func (s *testSigner) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, digest[:])
+		assert.Nil(t, err)
+	case "ES256":
+		r, sv, err := ecdsa.Sign(rand.Reader, s.ecKey, digest[:])
+		assert.Nil(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		sv.FillBytes(sig[32:])
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":    "alice",
+		"iss":    "https://idp.example.com",
+		"aud":    []string{"task-manager"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"tenant": "teamA",
+		"roles":  []string{"admin"},
+		"email":  "alice@example.com",
+	}
+}
+
+func withClaim(key string, value interface{}) map[string]interface{} {
+	claims := validClaims()
+	if value == nil {
+		delete(claims, key)
+	} else {
+		claims[key] = value
+	}
+	return claims
+}
+
+func TestJWTVerify(t *testing.T) {
+	signer := newTestSigner(t)
+	other := newTestSigner(t)
+	verifier, err := NewJWTVerifier(JWTConfig{JWKS: signer.writeJWKS(t), Issuer: "https://idp.example.com", Audience: "task-manager"})
+	assert.Nil(t, err)
+
+	unsigned := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"alice"}`)) + "."
+	cases := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"rs256", signer.sign(t, "RS256", "rsa-1", validClaims()), nil},
+		{"es256", signer.sign(t, "ES256", "ec-1", validClaims()), nil},
+		{"single audience", signer.sign(t, "RS256", "rsa-1", withClaim("aud", "task-manager")), nil},
+		{"expired", signer.sign(t, "RS256", "rsa-1", withClaim("exp", time.Now().Add(-time.Hour).Unix())), errTokenExpired},
+		{"no expiry", signer.sign(t, "RS256", "rsa-1", withClaim("exp", nil)), errTokenExpired},
+		{"not yet valid", signer.sign(t, "RS256", "rsa-1", withClaim("nbf", time.Now().Add(time.Hour).Unix())), errTokenNotYetValid},
+		{"wrong audience", signer.sign(t, "RS256", "rsa-1", withClaim("aud", "other-service")), errWrongAudience},
+		{"wrong issuer", signer.sign(t, "RS256", "rsa-1", withClaim("iss", "https://evil.example.com")), errWrongIssuer},
+		{"no issuer", signer.sign(t, "RS256", "rsa-1", withClaim("iss", nil)), errWrongIssuer},
+		{"no audience", signer.sign(t, "RS256", "rsa-1", withClaim("aud", nil)), errWrongAudience},
+		{"signed by another key", other.sign(t, "RS256", "rsa-1", validClaims()), errInvalidSignature},
+		{"alg swapped for kid", signer.sign(t, "ES256", "rsa-1", validClaims()), errInvalidSignature},
+		{"unknown kid", signer.sign(t, "RS256", "rsa-2", validClaims()), errUnknownKey},
+		{"alg none", unsigned, errUnsupportedAlg},
+		{"garbage", "not.a.jwt", errMalformedToken},
+	}
+
+	for _, c := range cases {
+		_, err := verifier.Verify(c.token)
+		assert.Equal(t, c.want, err, c.name)
+	}
+
+	_, err = NewJWTVerifier(JWTConfig{JWKS: signer.writeJWKS(t), Issuer: "https://idp.example.com"})
+	assert.EqualError(t, err, "JWT verification needs an issuer and an audience")
+}
+
+func TestJWTClaimsMapToIdentity(t *testing.T) {
+	signer := newTestSigner(t)
+	verifier, err := NewJWTVerifier(JWTConfig{JWKS: signer.writeJWKS(t), Issuer: "https://idp.example.com", Audience: "task-manager"})
+	assert.Nil(t, err)
+
+	identity, err := verifier.Verify(signer.sign(t, "ES256", "ec-1", validClaims()))
+	assert.Nil(t, err)
+	assert.Equal(t, "user:alice", identity.Subject)
+	assert.Equal(t, "alice@example.com", identity.Email)
+	assert.Equal(t, "teamA", identity.Tenant)
+	assert.Equal(t, []string{"admin"}, identity.Roles)
+	assert.True(t, identity.HasScope(scopeTasksAdmin))
+
+	claims := withClaim("scope", "tasks:read")
+	delete(claims, "roles")
+	identity, err = verifier.Verify(signer.sign(t, "ES256", "ec-1", claims))
+	assert.Nil(t, err)
+	assert.True(t, identity.HasScope(scopeTasksRead))
+	assert.False(t, identity.HasScope(scopeTasksWrite))
+}
+
+func TestBearerTokenAttachesIdentity(t *testing.T) {
+	signer := newTestSigner(t)
+	verifier, err := NewJWTVerifier(JWTConfig{JWKS: signer.writeJWKS(t), Issuer: "https://idp.example.com", Audience: "task-manager"})
+	assert.Nil(t, err)
+	keys, _ := LoadKeyStore("")
+	auth := NewAuthenticator(keys, verifier, true)
+
+	var seen *Identity
+	handler := auth.Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		seen, _ = identityFromContext(req.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+signer.sign(t, "RS256", "rsa-1", validClaims()))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "user:alice", seen.Subject)
+	assert.Equal(t, "jwt", seen.AuthMethod)
+
+	req = httptest.NewRequest(http.MethodGet, "/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+signer.sign(t, "RS256", "rsa-1", withClaim("aud", "other-service")))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestJWKSRefreshBacksOffAndDoesNotBlock(t *testing.T) {
+	signer := newTestSigner(t)
+	data, err := os.ReadFile(signer.writeJWKS(t))
+	assert.Nil(t, err)
+	var fetches int32
+	release := make(chan struct{})
+	idp := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch atomic.AddInt32(&fetches, 1) {
+		case 1:
+			res.Write(data)
+		case 2:
+			<-release
+			http.Error(res, "down", http.StatusServiceUnavailable)
+		default:
+			http.Error(res, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer idp.Close()
+	verifier, err := NewJWTVerifier(JWTConfig{JWKS: idp.URL, Issuer: "https://idp.example.com", Audience: "task-manager"})
+	assert.Nil(t, err)
+	now := time.Now().Add(2 * time.Minute)
+	verifier.now = func() time.Time { return now }
+
+	// a fetch for an unknown kid is in progress, known keys are still served
+	unknown := make(chan error)
+	go func() {
+		_, err := verifier.key("rsa-2")
+		unknown <- err
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) == 2 }, time.Second, time.Millisecond)
+	pub, err := verifier.key("rsa-1")
+	assert.Nil(t, err)
+	assert.NotNil(t, pub)
+	close(release)
+	assert.Equal(t, errUnknownKey, <-unknown)
+
+	// the failed fetch counts, the next one waits a minute
+	_, err = verifier.key("rsa-2")
+	assert.Equal(t, errUnknownKey, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	now = now.Add(2 * time.Minute)
+	_, err = verifier.key("rsa-2")
+	assert.Equal(t, errUnknownKey, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+}
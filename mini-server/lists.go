@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultListName = "default"
+
+var listNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type NewList struct {
+	Name string `json:"name"`
+}
+
+// ListInfo describes a list in JSON responses
+type ListInfo struct {
+	Name  string `json:"name"`
+	Tasks int    `json:"tasks"`
+}
+
+// ListStore holds every named task list (project) belonging to one tenant
+type ListStore struct {
+	mu       sync.Mutex
+	tenant   string
+	lists    map[string]*TaskList
+	maxTasks int // 0 means no limit
+	numTasks atomic.Int64
+}
+
+func NewListStore(tenant string, maxTasks int) *ListStore {
+	s := &ListStore{tenant: tenant, lists: make(map[string]*TaskList), maxTasks: maxTasks}
+	s.lists[defaultListName] = s.newList(defaultListName)
+	return s
+}
+
+func (s *ListStore) newList(name string) *TaskList {
+	return &TaskList{name: name, tenant: s.tenant, store: s}
+}
+
+// reserveTask counts a new task against the tenant's quota, returning false if the quota is used up
+func (s *ListStore) reserveTask() bool {
+	for {
+		n := s.numTasks.Load()
+		if s.maxTasks > 0 && n >= int64(s.maxTasks) {
+			return false
+		}
+		if s.numTasks.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// releaseTasks gives back quota when tasks are cleared
+func (s *ListStore) releaseTasks(n int) {
+	s.numTasks.Add(-int64(n))
+}
+
+// ReportGauges sends the task gauges of every list in the store
+func (s *ListStore) ReportGauges() {
+	s.mu.Lock()
+	lists := make([]*TaskList, 0, len(s.lists))
+	for _, list := range s.lists {
+		lists = append(lists, list)
+	}
+	s.mu.Unlock()
+	for _, list := range lists {
+		list.mu.Lock()
+		list.reportGauges()
+		list.mu.Unlock()
+	}
+}
+
+// ServeHTTP routes the task and list endpoints to this store's lists
+func (s *ListStore) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/":
+		s.Default().MainPageHandler(res, req)
+	case req.URL.Path == "/tasks":
+		s.Default().TasksHandler(res, req)
+	case req.URL.Path == "/tasks/add":
+		s.Default().AddTaskHandler(res, req)
+	case req.URL.Path == "/tasks/complete":
+		s.Default().CompleteTaskHandler(res, req)
+	case req.URL.Path == "/tasks/edit":
+		s.Default().EditTaskHandler(res, req)
+	case req.URL.Path == "/tasks/delete":
+		s.Default().DeleteTaskHandler(res, req)
+	case strings.HasPrefix(req.URL.Path, "/tasks/"):
+		s.Default().TaskHandler(res, req, strings.TrimPrefix(req.URL.Path, "/tasks/"))
+	case req.URL.Path == "/lists":
+		s.ListsHandler(res, req)
+	case strings.HasPrefix(req.URL.Path, "/lists/"):
+		s.ListRoutesHandler(res, req)
+	default:
+		http.NotFound(res, req)
+	}
+}
+
+// Default returns the list behind the original /tasks routes
+func (s *ListStore) Default() *TaskList {
+	list, _ := s.Get(defaultListName)
+	return list
+}
+
+func (s *ListStore) Get(name string) (*TaskList, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, ok := s.lists[name]
+	return list, ok
+}
+
+// Create adds an empty list, returning false if one with that name already exists
+func (s *ListStore) Create(name string) (*TaskList, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lists[name]; ok {
+		return nil, false
+	}
+	list := s.newList(name)
+	s.lists[name] = list
+	return list, true
+}
+
+// Names returns the names of all lists in sorted order
+func (s *ListStore) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.lists))
+	for name := range s.lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handler for /lists (Get all lists and create a new list)
+func (s *ListStore) ListsHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		lists := []ListInfo{}
+		for _, name := range s.Names() {
+			list, _ := s.Get(name)
+			list.mu.Lock()
+			lists = append(lists, ListInfo{Name: name, Tasks: len(list.tasks)})
+			list.mu.Unlock()
+		}
+		if wantsJSON(req) {
+			writeJSON(res, http.StatusOK, lists)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+		fmt.Fprint(res, "Getting all lists...\n")
+		for _, list := range lists {
+			fmt.Fprintf(res, "List:\n\tName = %s\n\tTasks = %d\n", list.Name, list.Tasks)
+		}
+	case "POST":
+		var newList NewList
+		err := json.NewDecoder(req.Body).Decode(&newList)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !listNamePattern.MatchString(newList.Name) {
+			http.Error(res, "list name must be 1-64 letters, digits, '-' or '_'", http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.Create(newList.Name); !ok {
+			http.Error(res, fmt.Sprintf("List %s already exists", newList.Name), http.StatusConflict)
+			return
+		}
+		if wantsJSON(req) {
+			writeJSON(res, http.StatusCreated, ListInfo{Name: newList.Name})
+		} else {
+			res.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(res, "Created list %s\n", newList.Name)
+		}
+		loggerFromContext(req.Context()).WithFields(log.Fields{"tenant": s.tenant, "list": newList.Name}).Info("Created task list")
+	default:
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handler for /lists/{list}/tasks routes, dispatching to the same handlers as /tasks and /tasks/{id}
+func (s *ListStore) ListRoutesHandler(res http.ResponseWriter, req *http.Request) {
+	name, route, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/lists/"), "/")
+	if !ok {
+		http.NotFound(res, req)
+		return
+	}
+	list, ok := s.Get(name)
+	if !ok {
+		http.Error(res, fmt.Sprintf("No list named %s", name), http.StatusNotFound)
+		return
+	}
+	switch route {
+	case "tasks":
+		list.TasksHandler(res, req)
+	case "tasks/add":
+		list.AddTaskHandler(res, req)
+	case "tasks/complete":
+		list.CompleteTaskHandler(res, req)
+	case "tasks/edit":
+		list.EditTaskHandler(res, req)
+	case "tasks/delete":
+		list.DeleteTaskHandler(res, req)
+	default:
+		if id, ok := strings.CutPrefix(route, "tasks/"); ok {
+			list.TaskHandler(res, req, id)
+			return
+		}
+		http.NotFound(res, req)
+	}
+}
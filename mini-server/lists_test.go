@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAndGetLists(t *testing.T) {
+	lists := NewListStore(defaultTenantName, 0)
+	cases := []struct {
+		method, body, want string
+		respCode           int
+	}{
+		{http.MethodPost, `{"name": "work"}`, "Created list work\n", http.StatusCreated},
+		{http.MethodPost, `{"name": "work"}`, "List work already exists\n", http.StatusConflict},
+		{http.MethodPost, `{"name": "bad/name"}`, "list name must be 1-64 letters, digits, '-' or '_'\n", http.StatusBadRequest},
+		{http.MethodGet, "", "Getting all lists...\nList:\n\tName = default\n\tTasks = 0\nList:\n\tName = work\n\tTasks = 0\n", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/lists", bytes.NewBufferString(c.body))
+		w := httptest.NewRecorder()
+		lists.ListsHandler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, c.want, string(data))
+		assert.Equal(t, c.respCode, res.StatusCode)
+	}
+}
+
+func TestListRoutesAreScopedPerList(t *testing.T) {
+	lists := NewListStore(defaultTenantName, 0)
+	lists.Create("work")
+	cases := []struct {
+		method, url, body, want string
+		respCode                int
+	}{
+		{http.MethodPost, "/lists/work/tasks/add", `{"id": 1, "title": "task1", "description": "boo1"}`, "Adding the following task to your task list\nTask:\n\tId = 1\n\tTitle = task1\n\tDescription = boo1\n\tCompleted = false", http.StatusCreated},
+		{http.MethodPatch, "/lists/default/tasks/complete", `{"id": 1}`, "No task with ID = 1 to complete\n", http.StatusOK},
+		{http.MethodGet, "/lists/default/tasks?showCompleted=true", "", "Getting all tasks...\nThere are no tasks!", http.StatusOK},
+		{http.MethodPatch, "/lists/work/tasks/complete", `{"id": 1}`, "Completed task with id 1\n", http.StatusOK},
+		{http.MethodGet, "/lists/work/tasks?showCompleted=true", "", "Getting all tasks...\nTask:\n\tId = 1\n\tTitle = task1\n\tDescription = boo1\n\tCompleted = true\n", http.StatusOK},
+		{http.MethodGet, "/lists/work/tasks/1", "", "Task:\n\tId = 1\n\tTitle = task1\n\tDescription = boo1\n\tCompleted = true\n", http.StatusOK},
+		{http.MethodGet, "/lists/default/tasks/1", "", "No task with ID = 1\n", http.StatusNotFound},
+		{http.MethodGet, "/lists/missing/tasks?showCompleted=true", "", "No list named missing\n", http.StatusNotFound},
+		{http.MethodGet, "/lists/work/other", "", "404 page not found\n", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, bytes.NewBufferString(c.body))
+		w := httptest.NewRecorder()
+		lists.ListRoutesHandler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, c.want, string(data))
+		assert.Equal(t, c.respCode, res.StatusCode)
+	}
+}
+
+func TestGetTaskByID(t *testing.T) {
+	lists := NewListStore(defaultTenantName, 0)
+	lists.Default().tasks = []Task{{Id: 7, Title: "seven"}}
+	cases := []struct {
+		method, url, want string
+		respCode          int
+	}{
+		{http.MethodGet, "/tasks/7", `{"id":7,"title":"seven","description":"","completed":false}` + "\n", http.StatusOK},
+		{http.MethodGet, "/tasks/8", "No task with ID = 8\n", http.StatusNotFound},
+		{http.MethodGet, "/tasks/seven", "invalid task ID \"seven\"\n", http.StatusBadRequest},
+		{http.MethodDelete, "/tasks/7", "method not allowed\n", http.StatusMethodNotAllowed},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		lists.ServeHTTP(w, req)
+		assert.Equal(t, c.want, w.Body.String(), c.url)
+		assert.Equal(t, c.respCode, w.Code, c.url)
+	}
+}
+
+func TestListMetricTags(t *testing.T) {
+	var unnamed TaskList
+	assert.Equal(t, []string{"environment:dev", "list:default", "tenant:default"}, unnamed.metricTags())
+
+	lists := NewListStore("teamA", 0)
+	work, ok := lists.Create("work")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"environment:dev", "list:work", "tenant:teamA"}, work.metricTags())
+}
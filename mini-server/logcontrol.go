@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const redacted = "[redacted]"
+
+// LogFilter sits in front of the real formatter, it samples high-volume messages and redacts
+// sensitive fields so they never reach the output
+type LogFilter struct {
+	next   log.Formatter
+	redact map[string]bool
+
+	mu     sync.Mutex
+	sample map[string]uint64 // message -> keep one in N
+	seen   map[string]uint64
+}
+
+func NewLogFilter(next log.Formatter, redact []string, sample map[string]uint64) *LogFilter {
+	f := &LogFilter{next: next, redact: make(map[string]bool), sample: sample, seen: make(map[string]uint64)}
+	for _, field := range redact {
+		f.redact[field] = true
+	}
+	return f
+}
+
+// keep decides whether a sampled message is written, warnings and errors always are
+func (f *LogFilter) keep(entry *log.Entry) bool {
+	if entry.Level <= log.WarnLevel {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.sample[entry.Message]
+	if !ok || n <= 1 {
+		return true
+	}
+	f.seen[entry.Message]++
+	return (f.seen[entry.Message]-1)%n == 0
+}
+
+func (f *LogFilter) Format(entry *log.Entry) ([]byte, error) {
+	if !f.keep(entry) {
+		return nil, nil
+	}
+	out := *entry
+	out.Data = make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if f.redact[k] {
+			v = redacted
+		}
+		out.Data[k] = v
+	}
+	return f.next.Format(&out)
+}
+
+// parseLogSample reads message:N sampling rules, keeping one in N of each message
+func parseLogSample(rules []string) (map[string]uint64, error) {
+	sample := make(map[string]uint64)
+	for _, rule := range rules {
+		i := strings.LastIndex(rule, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("log sample %q is not message:N", rule)
+		}
+		n, err := strconv.ParseUint(rule[i+1:], 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("log sample %q needs a positive rate", rule)
+		}
+		sample[rule[:i]] = n
+	}
+	return sample, nil
+}
+
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// handler for /admin/log-level (get and change the log level without a restart)
+func LogLevelHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "PUT":
+		var body LogLevel
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := log.ParseLevel(body.Level)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.SetLevel(level)
+		loggerFromContext(req.Context()).WithField("level", level.String()).Warn("Changed log level")
+	default:
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(LogLevel{Level: log.GetLevel().String()})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogs sends the global logger's output through a LogFilter into a buffer for the test
+func captureLogs(t *testing.T, redact []string, sample map[string]uint64) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFormatter(NewLogFilter(&log.JSONFormatter{}, redact, sample))
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(&log.TextFormatter{})
+	})
+	return &buf
+}
+
+func TestRedactedTaskFieldsNeverLogged(t *testing.T) {
+	buf := captureLogs(t, []string{"title", "description"}, nil)
+
+	var taskList TaskList
+	body := `{"id":1,"title":"launch codes","description":"0000 is the PIN"}`
+	taskList.AddTaskHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/tasks/add", strings.NewReader(body)))
+
+	out := buf.String()
+	assert.NotContains(t, out, "launch codes")
+	assert.NotContains(t, out, "0000 is the PIN")
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(strings.Split(out, "\n")[0]), &line))
+	assert.Equal(t, "Added task", line["msg"])
+	assert.Equal(t, redacted, line["title"])
+	assert.Equal(t, redacted, line["description"])
+	assert.Equal(t, 1.0, line["task_id"])
+}
+
+func TestLogSamplingKeepsOneInN(t *testing.T) {
+	buf := captureLogs(t, nil, map[string]uint64{"Access": 3})
+	for i := 0; i < 7; i++ {
+		log.Info("Access")
+		log.Info("Other")
+	}
+	log.Warn("Access")
+
+	out := buf.String()
+	assert.Equal(t, 3+1, strings.Count(out, `"msg":"Access"`))
+	assert.Equal(t, 7, strings.Count(out, `"msg":"Other"`))
+}
+
+func TestParseLogSample(t *testing.T) {
+	sample, err := parseLogSample([]string{"Access:10", "Added task:2"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]uint64{"Access": 10, "Added task": 2}, sample)
+
+	_, err = parseLogSample([]string{"Access"})
+	assert.EqualError(t, err, `log sample "Access" is not message:N`)
+	_, err = parseLogSample([]string{"Access:0"})
+	assert.EqualError(t, err, `log sample "Access:0" needs a positive rate`)
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	defer log.SetLevel(log.InfoLevel)
+	keys, api := newTestAPI(t, "")
+	api.(*http.ServeMux).Handle("/admin/log-level", NewAuthenticator(keys, nil, true).Middleware(http.HandlerFunc(LogLevelHandler)))
+	writer := mintKey(t, api, defaultTenantName, scopeTasksAdmin)
+
+	assert.Equal(t, http.StatusForbidden, doRequest(api, http.MethodPut, "/admin/log-level", writer.Key, `{"level":"debug"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(api, http.MethodPut, "/admin/log-level", testAdminKey, `{"level":"loud"}`).Code)
+
+	w := doRequest(api, http.MethodPut, "/admin/log-level", testAdminKey, `{"level":"debug"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, log.DebugLevel, log.GetLevel())
+
+	w = doRequest(api, http.MethodGet, "/admin/log-level", testAdminKey, "")
+	assert.JSONEq(t, `{"level":"debug"}`, w.Body.String())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type loggerKey struct{}
+
+// requestLogger is the logger of one request, middleware further down the chain can add fields
+// to it, like the user once auth has run, and they show up in the access log too
+type requestLogger struct {
+	mu    sync.Mutex
+	entry *log.Entry
+}
+
+func (l *requestLogger) get() *log.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.entry
+}
+
+// loggerFromContext returns the request-scoped logger, or one with the standard fields outside a request
+func loggerFromContext(ctx context.Context) *log.Entry {
+	if l, ok := ctx.Value(loggerKey{}).(*requestLogger); ok {
+		return l.get()
+	}
+	return log.WithFields(standardFields)
+}
+
+// addLogFields adds fields to the request-scoped logger in ctx, if any
+func addLogFields(ctx context.Context, fields log.Fields) {
+	if l, ok := ctx.Value(loggerKey{}).(*requestLogger); ok {
+		l.mu.Lock()
+		l.entry = l.entry.WithFields(fields)
+		l.mu.Unlock()
+	}
+}
+
+// validRequestID accepts caller-supplied ids that are short and printable so they are safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLogging propagates or assigns an X-Request-ID, puts a logger carrying it into the
+// request context and writes one access log line when the request is done
+func requestLogging(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		res.Header().Set(requestIDHeader, id)
+
+		fields := log.Fields{"request_id": id, "route": route}
+		if traceID := tracing.TraceID(req.Context()); traceID != "" {
+			fields["trace_id"] = traceID
+		}
+		l := &requestLogger{entry: log.WithFields(standardFields).WithFields(fields)}
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), loggerKey{}, l)))
+
+		l.get().WithFields(log.Fields{
+			"method":      req.Method,
+			"path":        req.URL.Path,
+			"status":      rec.status,
+			"bytes":       rec.bytes,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+		}).Info("Access")
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func accessLog(hook *test.Hook) *log.Entry {
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Access" {
+			return entry
+		}
+	}
+	return nil
+}
+
+func TestRequestIDPropagatedOrAssigned(t *testing.T) {
+	handler := requestLogging("/tasks", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		loggerFromContext(req.Context()).Info("inside")
+	}))
+	cases := []struct {
+		incoming string
+		kept     bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"has spaces", false},
+	}
+	for _, c := range cases {
+		hook := test.NewGlobal()
+		req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+		req.Header.Set(requestIDHeader, c.incoming)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		id := w.Header().Get(requestIDHeader)
+		if c.kept {
+			assert.Equal(t, c.incoming, id)
+		} else {
+			assert.Len(t, id, 32)
+		}
+		assert.Equal(t, "inside", hook.Entries[0].Message)
+		assert.Equal(t, id, hook.Entries[0].Data["request_id"])
+		assert.Equal(t, id, accessLog(hook).Data["request_id"])
+	}
+}
+
+func TestAccessLogCarriesUserStatusAndBytes(t *testing.T) {
+	hook := test.NewGlobal()
+	_, api := newTestAPI(t, "")
+	handler := requestLogging("/tasks/add", api)
+	req := httptest.NewRequest(http.MethodPost, "/tasks/add", nil)
+	req.Header.Set(apiKeyHeader, testAdminKey)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	entry := accessLog(hook)
+	assert.NotNil(t, entry)
+	assert.Equal(t, "/tasks/add", entry.Data["route"])
+	assert.Equal(t, "POST", entry.Data["method"])
+	assert.Equal(t, http.StatusBadRequest, entry.Data["status"])
+	assert.Equal(t, w.Body.Len(), entry.Data["bytes"])
+	assert.Equal(t, "key:admin", entry.Data["user"])
+	assert.Contains(t, entry.Data, "duration_ms")
+}
+
+func TestRequestLoggerCarriesTraceID(t *testing.T) {
+	_, endpoint := newCollector(t)
+	otel := newOTelTracer(TracingOptions{OTLPEndpoint: endpoint})
+	tracing = otel
+	defer func() { tracing = noopTracer{}; otel.Stop() }()
+
+	hook := test.NewGlobal()
+	handler := otel.Middleware("/tasks", requestLogging("/tasks", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", accessLog(hook).Data["trace_id"])
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
+)
+
+// Metrics is where the server sends its metrics, names use the DogStatsD dotted style and tags are key:value
+type Metrics interface {
+	Gauge(name string, value float64, tags []string)
+	Count(name string, value int64, tags []string)
+	Histogram(name string, value float64, tags []string)
+	Close() error
+}
+
+// MetricsOptions configures whichever backend NewMetrics builds
+type MetricsOptions struct {
+	StatsdAddr   string
+	OTLPEndpoint string
+	Service      string
+	Env          string
+}
+
+// NewMetrics builds the named backend: statsd, prometheus, otlp or none
+func NewMetrics(backend string, opts MetricsOptions) (Metrics, error) {
+	switch backend {
+	case "statsd":
+		c, err := statsd.New(opts.StatsdAddr)
+		if err != nil {
+			return nil, err
+		}
+		return &StatsdMetrics{client: c}, nil
+	case "prometheus":
+		return telemetry.NewPrometheusMetrics(), nil
+	case "otlp":
+		return NewOTLPMetrics(opts), nil
+	case "none":
+		return NoopMetrics{}, nil
+	}
+	return nil, fmt.Errorf("unknown metrics backend %q", backend)
+}
+
+// NoopMetrics drops everything, it is the default until main picks a backend
+type NoopMetrics struct{}
+
+func (NoopMetrics) Gauge(string, float64, []string)     {}
+func (NoopMetrics) Count(string, int64, []string)       {}
+func (NoopMetrics) Histogram(string, float64, []string) {}
+func (NoopMetrics) Close() error                        { return nil }
+
+// StatsdMetrics sends metrics to a DogStatsD agent
+type StatsdMetrics struct {
+	client *statsd.Client
+}
+
+func (m *StatsdMetrics) Gauge(name string, value float64, tags []string) {
+	m.client.Gauge(name, value, tags, 1)
+}
+
+func (m *StatsdMetrics) Count(name string, value int64, tags []string) {
+	m.client.Count(name, value, tags, 1)
+}
+
+func (m *StatsdMetrics) Histogram(name string, value float64, tags []string) {
+	m.client.Histogram(name, value, tags, 1)
+}
+
+// Close flushes buffered metrics before closing the connection
+func (m *StatsdMetrics) Close() error {
+	m.client.Flush()
+	return m.client.Close()
+}
+
+// statusRecorder remembers the status code and number of bytes a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// httpMethods are the methods instrument tags as is, anything else is counted as other so a
+// client cannot mint series
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+type metricTenantKey struct{}
+
+// setMetricTenant tags the request's metrics with the caller's tenant, auth runs inside instrument
+// so it cannot hand the identity back through the context
+func setMetricTenant(ctx context.Context, tenant string) {
+	if t, ok := ctx.Value(metricTenantKey{}).(*string); ok {
+		*t = tenant
+	}
+}
+
+// instrument counts requests to route and records their latency, route is the registered
+// pattern so the number of series stays bounded. Requests without an identity have tenant none.
+func instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		tenant := "none"
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), metricTenantKey{}, &tenant)))
+		method := req.Method
+		if !httpMethods[method] {
+			method = "other"
+		}
+		tags := withGlobalTags("route:"+route, "method:"+method, "status:"+strconv.Itoa(rec.status), "tenant:"+tenant)
+		metrics.Count("http.requests.count", 1, tags)
+		metrics.Histogram("http.request.duration_seconds", time.Since(start).Seconds(), tags)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
+)
+
+func scrape(m *telemetry.PrometheusMetrics) string {
+	res := httptest.NewRecorder()
+	m.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	return res.Body.String()
+}
+
+func TestInstrumentCountsRequestsByRoute(t *testing.T) {
+	prom := telemetry.NewPrometheusMetrics()
+	metrics = prom
+	defer func() { metrics = NoopMetrics{} }()
+
+	handler := instrument("/tasks", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" {
+			http.Error(res, "nope", http.StatusForbidden)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tasks", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tasks", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/tasks", nil))
+
+	body := scrape(prom)
+	assert.Contains(t, body, `http_requests_total{environment="dev",method="GET",route="/tasks",status="200",tenant="none"} 2`)
+	assert.Contains(t, body, `http_requests_total{environment="dev",method="POST",route="/tasks",status="403",tenant="none"} 1`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{environment="dev",method="GET",route="/tasks",status="200",tenant="none"} 2`)
+}
+
+func TestInstrumentTagsTenantAndUnknownMethods(t *testing.T) {
+	prom := telemetry.NewPrometheusMetrics()
+	metrics = prom
+	defer func() { metrics = NoopMetrics{} }()
+	keys, _ := LoadKeyStore("")
+	keys.Add(testAdminKey, "teamA", []string{scopeTasksAdmin})
+	auth := NewAuthenticator(keys, nil, true)
+
+	handler := instrument("/tasks", auth.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	req := httptest.NewRequest("GET", "/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/tasks", nil))
+
+	body := scrape(prom)
+	assert.Contains(t, body, `http_requests_total{environment="dev",method="GET",route="/tasks",status="200",tenant="teamA"} 1`)
+	assert.Contains(t, body, `http_requests_total{environment="dev",method="other",route="/tasks",status="401",tenant="none"} 1`)
+}
+
+func TestInstrumentTagsTenantFromHeaderWithoutAuth(t *testing.T) {
+	prom := telemetry.NewPrometheusMetrics()
+	metrics = prom
+	defer func() { metrics = NoopMetrics{} }()
+
+	hook := test.NewGlobal()
+
+	handler := requestLogging("/tasks", instrument("/tasks", NewTenantStore(TenantConfig{AllowUnlisted: true})))
+	req := httptest.NewRequest("GET", "/tasks?showCompleted=true", nil)
+	req.Header.Set(tenantHeader, "teamA")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Contains(t, scrape(prom), `http_requests_total{environment="dev",method="GET",route="/tasks",status="200",tenant="teamA"} 1`)
+	assert.Equal(t, "teamA", accessLog(hook).Data["tenant"])
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
+)
+
+// otelTracer sends spans to an OTLP/HTTP collector, continuing traces from an incoming traceparent
+type otelTracer struct {
+	*telemetry.Tracer
+}
+
+func newOTelTracer(opts TracingOptions) *otelTracer {
+	exporter := telemetry.NewExporter(opts.OTLPEndpoint, opts.Service, []string{"environment:" + opts.Env})
+	return &otelTracer{telemetry.NewTracer(exporter, func(err error, spans int) {
+		log.WithFields(standardFields).WithError(err).WithField("spans", spans).Warn("Failed to export spans")
+	})}
+}
+
+func (t *otelTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return t.Start(ctx, name, telemetry.SpanKindInternal, nil)
+}
+
+func (t *otelTracer) TraceID(ctx context.Context) string {
+	span, ok := telemetry.SpanFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	traceID := span.Context().TraceID
+	return hex.EncodeToString(traceID[:])
+}
+
+func (t *otelTracer) Middleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var remote *telemetry.SpanContext
+		if sc, ok := telemetry.ParseTraceparent(req.Header.Get(telemetry.TraceparentHeader)); ok {
+			remote = &sc
+		}
+		ctx, span := t.Start(req.Context(), req.Method+" "+route, telemetry.SpanKindServer, remote)
+		span.SetTag("http.method", req.Method)
+		span.SetTag("http.route", route)
+		span.SetTag("http.target", req.URL.RequestURI())
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(rec, req.WithContext(ctx))
+		span.SetTag("http.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(errors.New(http.StatusText(rec.status)))
+		}
+		span.Finish()
+	})
+}
+
+// OTLPMetrics aggregates metrics in memory and pushes them to an OTLP/HTTP collector
+type OTLPMetrics struct {
+	agg      *telemetry.PrometheusMetrics
+	exporter *telemetry.Exporter
+	start    time.Time
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewOTLPMetrics(opts MetricsOptions) *OTLPMetrics {
+	m := &OTLPMetrics{
+		agg:      telemetry.NewPrometheusMetrics(),
+		exporter: telemetry.NewExporter(opts.OTLPEndpoint, opts.Service, []string{"environment:" + opts.Env}),
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *OTLPMetrics) Gauge(name string, value float64, tags []string) {
+	m.agg.Gauge(name, value, tags)
+}
+
+func (m *OTLPMetrics) Count(name string, value int64, tags []string) {
+	m.agg.Count(name, value, tags)
+}
+
+func (m *OTLPMetrics) Histogram(name string, value float64, tags []string) {
+	m.agg.Histogram(name, value, tags)
+}
+
+func (m *OTLPMetrics) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(telemetry.ExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			m.export()
+			return
+		case <-ticker.C:
+			m.export()
+		}
+	}
+}
+
+func (m *OTLPMetrics) export() {
+	if err := m.exporter.ExportMetrics(m.agg, m.start, time.Now()); err != nil {
+		log.WithFields(standardFields).WithError(err).Warn("Failed to export metrics")
+	}
+}
+
+// Close exports the final values
+func (m *OTLPMetrics) Close() error {
+	close(m.stop)
+	<-m.done
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/mini-golang-project/telemetry"
+)
+
+// collector is a fake OTLP/HTTP endpoint that keeps every payload it receives
+type collector struct {
+	mu       sync.Mutex
+	payloads map[string][][]byte
+}
+
+func newCollector(t *testing.T) (*collector, string) {
+	c := &collector{payloads: make(map[string][][]byte)}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		buf.ReadFrom(req.Body)
+		c.mu.Lock()
+		c.payloads[req.URL.Path] = append(c.payloads[req.URL.Path], buf.Bytes())
+		c.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func (c *collector) spans(t *testing.T) map[string]telemetry.OTLPSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := make(map[string]telemetry.OTLPSpan)
+	for _, payload := range c.payloads["/v1/traces"] {
+		var req telemetry.OTLPTracesRequest
+		assert.Nil(t, json.Unmarshal(payload, &req))
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func TestOTelTracerContinuesIncomingTrace(t *testing.T) {
+	c, endpoint := newCollector(t)
+	otel := newOTelTracer(TracingOptions{Service: "tasks-staging", Env: "test", OTLPEndpoint: endpoint})
+	tracing = otel
+	defer func() { tracing = noopTracer{} }()
+
+	var taskList TaskList
+	handler := otel.Middleware("/tasks/add", http.HandlerFunc(taskList.AddTaskHandler))
+	req := httptest.NewRequest(http.MethodPost, "/tasks/add", bytes.NewBufferString(`{"id":7,"title":"traced"}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	otel.Stop()
+
+	spans := c.spans(t)
+	server, store := spans["POST /tasks/add"], spans["store.add"]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID)
+	assert.Equal(t, telemetry.SpanKindServer, server.Kind)
+	assert.Contains(t, server.Attributes, telemetry.Attribute("http.status_code", http.StatusCreated))
+	assert.Equal(t, server.TraceID, store.TraceID)
+	assert.Equal(t, server.SpanID, store.ParentSpanID)
+	assert.Contains(t, store.Attributes, telemetry.Attribute("task.id", int64(7)))
+}
+
+func TestOTelTracerSkipsUnsampledTraces(t *testing.T) {
+	c, endpoint := newCollector(t)
+	otel := newOTelTracer(TracingOptions{OTLPEndpoint: endpoint})
+
+	handler := otel.Middleware("/tasks", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	otel.Stop()
+
+	assert.Empty(t, c.spans(t))
+}
+
+func TestOTLPMetricsExport(t *testing.T) {
+	c, endpoint := newCollector(t)
+	m := NewOTLPMetrics(MetricsOptions{Service: "tasks-staging", Env: "test", OTLPEndpoint: endpoint})
+	m.Count("auth_failures.count", 2, []string{"reason:missing"})
+	assert.Nil(t, m.Close())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.Len(t, c.payloads["/v1/metrics"], 1)
+	var req telemetry.OTLPMetricsRequest
+	assert.Nil(t, json.Unmarshal(c.payloads["/v1/metrics"][0], &req))
+	rm := req.ResourceMetrics[0]
+	assert.Contains(t, rm.Resource.Attributes, telemetry.Attribute("service.name", "tasks-staging"))
+	assert.Contains(t, rm.Resource.Attributes, telemetry.Attribute("deployment.environment", "test"))
+	assert.Equal(t, "tasks-staging", rm.ScopeMetrics[0].Scope.Name)
+	assert.Equal(t, "auth_failures", rm.ScopeMetrics[0].Metrics[0].Name)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
+)
+
+// profileTypes maps the profile-types config values to Datadog profile types
+var profileTypes = map[string]profiler.ProfileType{
+	"cpu":       profiler.CPUProfile,
+	"heap":      profiler.HeapProfile,
+	"goroutine": profiler.GoroutineProfile,
+	"mutex":     profiler.MutexProfile,
+	"block":     profiler.BlockProfile,
+}
+
+// ProfilingOptions says which profiles to collect and where to send them
+type ProfilingOptions struct {
+	Service string
+	Env     string
+	Types   []string
+	Datadog bool
+}
+
+// enableRuntimeProfiles turns on the sampling mutex and block profiles need, they are off by
+// default in the runtime and /debug/pprof would serve empty profiles
+func enableRuntimeProfiles(types []string) {
+	for _, t := range types {
+		switch t {
+		case "mutex":
+			runtime.SetMutexProfileFraction(100)
+		case "block":
+			runtime.SetBlockProfileRate(10000)
+		}
+	}
+}
+
+// StartProfiling starts the Datadog profiler when enabled, the caller should only warn on error
+func StartProfiling(opts ProfilingOptions) error {
+	enableRuntimeProfiles(opts.Types)
+	if !opts.Datadog {
+		return nil
+	}
+	types := make([]profiler.ProfileType, 0, len(opts.Types))
+	for _, t := range opts.Types {
+		pt, ok := profileTypes[t]
+		if !ok {
+			return fmt.Errorf("unknown profile type %q", t)
+		}
+		types = append(types, pt)
+	}
+	return profiler.Start(
+		profiler.WithService(opts.Service),
+		profiler.WithEnv(opts.Env),
+		profiler.WithProfileTypes(types...),
+	)
+}
+
+// StopProfiling stops the Datadog profiler if it was started
+func StopProfiling() {
+	profiler.Stop()
+}
+
+// pprofHandler serves the net/http/pprof endpoints under /debug/pprof/
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
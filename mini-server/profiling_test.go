@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartProfilingWithoutDatadog(t *testing.T) {
+	assert.Nil(t, StartProfiling(ProfilingOptions{Types: []string{"cpu", "heap"}}))
+	assert.EqualError(t, StartProfiling(ProfilingOptions{Datadog: true, Types: []string{"threads"}}), `unknown profile type "threads"`)
+}
+
+func TestPprofRequiresKeysAdmin(t *testing.T) {
+	keys, api := newTestAPI(t, "")
+	mux := api.(*http.ServeMux)
+	mux.Handle("/debug/pprof/", NewAuthenticator(keys, nil, true).Middleware(pprofHandler()))
+	writer := mintKey(t, api, defaultTenantName, scopeTasksAdmin)
+
+	assert.Equal(t, http.StatusUnauthorized, doRequest(api, http.MethodGet, "/debug/pprof/", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, doRequest(api, http.MethodGet, "/debug/pprof/", writer.Key, "").Code)
+
+	w := doRequest(api, http.MethodGet, "/debug/pprof/goroutine?debug=1", testAdminKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine profile")
+}
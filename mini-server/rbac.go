@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	actionView     = "view"
+	actionEdit     = "edit"
+	actionComplete = "complete"
+	actionDelete   = "delete"
+
+	principalAny      = "any"
+	principalOwner    = "owner"
+	principalAssignee = "assignee"
+	rolePrefix        = "role:"
+)
+
+// Policy maps each action on a task to the principals allowed to perform it:
+// "any", "owner", "assignee" or "role:<name>"
+type Policy map[string][]string
+
+var defaultPolicy = Policy{
+	actionView:     {principalAny},
+	actionEdit:     {principalOwner, principalAssignee, "role:admin"},
+	actionComplete: {principalOwner, principalAssignee, "role:admin"},
+	actionDelete:   {principalOwner, "role:admin"},
+}
+
+func (p Policy) validate() error {
+	for _, action := range []string{actionView, actionEdit, actionComplete, actionDelete} {
+		if _, ok := p[action]; !ok {
+			return fmt.Errorf("policy has no rule for %s", action)
+		}
+	}
+	for action, principals := range p {
+		switch action {
+		case actionView, actionEdit, actionComplete, actionDelete:
+		default:
+			return fmt.Errorf("unknown action %q in policy", action)
+		}
+		for _, principal := range principals {
+			switch {
+			case principal == principalAny, principal == principalOwner, principal == principalAssignee:
+			case strings.HasPrefix(principal, rolePrefix) && len(principal) > len(rolePrefix):
+			default:
+				return fmt.Errorf("unknown principal %q for %s", principal, action)
+			}
+		}
+	}
+	return nil
+}
+
+// HasRole reports whether the identity holds role, keys and tokens with tasks:admin count as admins
+func (i *Identity) HasRole(role string) bool {
+	if role == "admin" && i.HasScope(scopeTasksAdmin) {
+		return true
+	}
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (task Task) isAssignee(subject string) bool {
+	for _, a := range task.Assignees {
+		if a == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// describe turns a rule into the "only the owner or an admin" part of a denial
+func describe(principals []string) string {
+	names := make([]string, 0, len(principals))
+	for _, principal := range principals {
+		switch {
+		case principal == principalOwner:
+			names = append(names, "the owner")
+		case principal == principalAssignee:
+			names = append(names, "an assignee")
+		case principal == "role:admin":
+			names = append(names, "an admin")
+		case strings.HasPrefix(principal, rolePrefix):
+			names = append(names, "a user with role "+strings.TrimPrefix(principal, rolePrefix))
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "nobody"
+	case 1:
+		return "only " + names[0]
+	}
+	return "only " + strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// PolicyStore holds the active policy and reloads it from its file on request
+type PolicyStore struct {
+	mu     sync.RWMutex
+	policy Policy
+	path   string
+}
+
+// LoadPolicyStore reads the policy from a JSON file, with no file the default policy is used
+func LoadPolicyStore(path string) (*PolicyStore, error) {
+	s := &PolicyStore{policy: defaultPolicy, path: path}
+	if path == "" {
+		return s, nil
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the policy file, keeping the current policy if the new one is invalid
+func (s *PolicyStore) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("reading policy: %w", err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return fmt.Errorf("parsing policy %s: %w", s.path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return fmt.Errorf("policy %s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	s.policy = policy
+	s.mu.Unlock()
+	return nil
+}
+
+// Allowed decides whether the caller of req may perform action on task, returning the reason when it may not.
+// Requests without an identity only reach the handlers when authentication is disabled and are always allowed.
+func (s *PolicyStore) Allowed(req *http.Request, action string, task Task) (bool, string) {
+	identity, ok := identityFromContext(req.Context())
+	if !ok {
+		return true, ""
+	}
+	s.mu.RLock()
+	principals := s.policy[action]
+	s.mu.RUnlock()
+	for _, principal := range principals {
+		switch {
+		case principal == principalAny:
+			return true, ""
+		case principal == principalOwner && task.Owner != "" && task.Owner == identity.Subject:
+			return true, ""
+		case principal == principalAssignee && task.isAssignee(identity.Subject):
+			return true, ""
+		case strings.HasPrefix(principal, rolePrefix) && identity.HasRole(strings.TrimPrefix(principal, rolePrefix)):
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("%s can %s task %d", describe(principals), action, task.Id)
+}
+
+// handler for /admin/policy/reload
+func (s *PolicyStore) ReloadHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Reload(); err != nil {
+		loggerFromContext(req.Context()).WithError(err).Error("Failed to reload access policy")
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	loggerFromContext(req.Context()).WithField("path", s.path).Info("Reloaded access policy")
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	alice = &Identity{Subject: "user:alice", Scopes: []string{scopeTasksWrite}}
+	bob   = &Identity{Subject: "user:bob", Scopes: []string{scopeTasksWrite}}
+	carol = &Identity{Subject: "user:carol", Scopes: []string{scopeTasksWrite}, Roles: []string{"admin"}}
+)
+
+func requestAs(identity *Identity, method, url, body string) *http.Request {
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	return req.WithContext(context.WithValue(req.Context(), identityKey{}, identity))
+}
+
+func TestTaskOwnershipEnforced(t *testing.T) {
+	lists := NewListStore(defaultTenantName, 0)
+	cases := []struct {
+		who                     *Identity
+		method, url, body, want string
+		respCode                int
+	}{
+		{alice, http.MethodPost, "/tasks/add", `{"id": 1, "title": "task1", "description": "boo1", "assignees": ["user:dave"]}`, "Adding the following task to your task list\nTask:\n\tId = 1\n\tTitle = task1\n\tDescription = boo1\n\tCompleted = false\n\tOwner = user:alice\n\tAssignees = user:dave", http.StatusCreated},
+		{bob, http.MethodPatch, "/tasks/complete", `{"id": 1}`, "only the owner, an assignee or an admin can complete task 1\n", http.StatusForbidden},
+		{bob, http.MethodPatch, "/tasks/edit", `{"id": 1, "title": "mine now"}`, "only the owner, an assignee or an admin can edit task 1\n", http.StatusForbidden},
+		{bob, http.MethodDelete, "/tasks/delete", `{"id": 1}`, "only the owner or an admin can delete task 1\n", http.StatusForbidden},
+		{&Identity{Subject: "user:dave"}, http.MethodDelete, "/tasks/delete", `{"id": 1}`, "only the owner or an admin can delete task 1\n", http.StatusForbidden},
+		{&Identity{Subject: "user:dave"}, http.MethodPatch, "/tasks/edit", `{"id": 1, "title": "renamed"}`, "Updated the following task\nTask:\n\tId = 1\n\tTitle = renamed\n\tDescription = boo1\n\tCompleted = false\n\tOwner = user:alice\n\tAssignees = user:dave", http.StatusOK},
+		{bob, http.MethodGet, "/tasks?showCompleted=true", "", "Getting all tasks...\nTask:\n\tId = 1\n\tTitle = renamed\n\tDescription = boo1\n\tCompleted = false\n\tOwner = user:alice\n\tAssignees = user:dave\n", http.StatusOK},
+		{carol, http.MethodPatch, "/tasks/complete", `{"id": 1}`, "Completed task with id 1\n", http.StatusOK},
+		{carol, http.MethodDelete, "/tasks/delete", `{"id": 1}`, "", http.StatusNoContent},
+		{alice, http.MethodDelete, "/tasks/delete", `{"id": 1}`, "No task with ID = 1 to delete\n", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		lists.ServeHTTP(w, requestAs(c.who, c.method, c.url, c.body))
+		assert.Equal(t, c.want, w.Body.String(), "%s %s as %s", c.method, c.url, c.who.Subject)
+		assert.Equal(t, c.respCode, w.Code)
+	}
+}
+
+func TestPolicyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"view": ["owner"], "edit": ["owner"], "complete": ["owner"], "delete": ["role:admin"]}`), 0o600))
+	store, err := LoadPolicyStore(path)
+	assert.Nil(t, err)
+
+	task := Task{Id: 7, Owner: "user:alice"}
+	allowed, _ := store.Allowed(requestAs(bob, http.MethodGet, "/tasks", ""), actionView, task)
+	assert.False(t, allowed)
+	allowed, reason := store.Allowed(requestAs(alice, http.MethodDelete, "/tasks/delete", ""), actionDelete, task)
+	assert.False(t, allowed)
+	assert.Equal(t, "only an admin can delete task 7", reason)
+
+	// an invalid policy is rejected and the previous one stays in force
+	assert.Nil(t, os.WriteFile(path, []byte(`{"view": ["everyone"]}`), 0o600))
+	assert.NotNil(t, store.Reload())
+	allowed, _ = store.Allowed(requestAs(bob, http.MethodGet, "/tasks", ""), actionView, task)
+	assert.False(t, allowed)
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"view": ["any"], "edit": ["owner"], "complete": ["owner"], "delete": ["owner"]}`), 0o600))
+	assert.Nil(t, store.Reload())
+	allowed, _ = store.Allowed(requestAs(bob, http.MethodGet, "/tasks", ""), actionView, task)
+	assert.True(t, allowed)
+	allowed, _ = store.Allowed(requestAs(alice, http.MethodDelete, "/tasks/delete", ""), actionDelete, task)
+	assert.True(t, allowed)
+}
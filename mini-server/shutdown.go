@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// serve runs listen until it fails or ctx is done. Once ctx is done readiness starts failing,
+// the server stops accepting connections and in-flight requests get up to timeout to finish.
+func serve(ctx context.Context, server *http.Server, listen func() error, timeout time.Duration, health *Health) error {
+	errc := make(chan error, 1)
+	go func() { errc <- listen() }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	health.SetDraining()
+
+	log.WithFields(standardFields).WithField("timeout", timeout.String()).Info("Shutting down, draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if listenErr := <-errc; !errors.Is(listenErr, http.ErrServerClosed) && err == nil {
+		err = listenErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.WithFields(standardFields).Warn("Shutdown deadline passed with requests still in flight")
+		server.Close()
+	}
+	return err
+}
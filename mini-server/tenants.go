@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultTenantName = "default"
+	tenantHeader      = "X-Tenant-ID"
+)
+
+// TenantQuota limits how much of the deployment a tenant can use, zero values mean no limit
+type TenantQuota struct {
+	MaxTasks          int     `json:"max_tasks"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// TenantConfig is the format of the file passed with -tenants
+type TenantConfig struct {
+	DefaultQuota  TenantQuota            `json:"default_quota"`
+	AllowUnlisted bool                   `json:"allow_unlisted"`
+	Tenants       map[string]TenantQuota `json:"tenants"`
+}
+
+type Tenant struct {
+	Name    string
+	Quota   TenantQuota
+	lists   *ListStore
+	limiter *rate.Limiter
+}
+
+func newTenant(name string, quota TenantQuota) *Tenant {
+	t := &Tenant{Name: name, Quota: quota, lists: NewListStore(name, quota.MaxTasks)}
+	if quota.RequestsPerSecond > 0 {
+		burst := quota.Burst
+		if burst < 1 {
+			burst = int(math.Ceil(quota.RequestsPerSecond))
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(quota.RequestsPerSecond), burst)
+	}
+	return t
+}
+
+// TenantStore partitions all task storage by tenant
+type TenantStore struct {
+	mu            sync.Mutex
+	tenants       map[string]*Tenant
+	defaultQuota  TenantQuota
+	allowUnlisted bool
+}
+
+func NewTenantStore(config TenantConfig) *TenantStore {
+	s := &TenantStore{
+		tenants:       make(map[string]*Tenant),
+		defaultQuota:  config.DefaultQuota,
+		allowUnlisted: config.AllowUnlisted,
+	}
+	for name, quota := range config.Tenants {
+		s.tenants[name] = newTenant(name, quota)
+	}
+	if _, ok := s.tenants[defaultTenantName]; !ok {
+		s.tenants[defaultTenantName] = newTenant(defaultTenantName, config.DefaultQuota)
+	}
+	return s
+}
+
+// LoadTenantStore reads tenant quotas from a JSON file, with no file every tenant is allowed and unlimited
+func LoadTenantStore(path string) (*TenantStore, error) {
+	if path == "" {
+		return NewTenantStore(TenantConfig{AllowUnlisted: true}), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tenant config: %w", err)
+	}
+	var config TenantConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing tenant config %s: %w", path, err)
+	}
+	for name := range config.Tenants {
+		if !listNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid tenant name %q in %s", name, path)
+		}
+	}
+	return NewTenantStore(config), nil
+}
+
+// ReportGauges sends the task gauges of every tenant's lists every interval until stop is
+// closed, so gauges stay fresh between writes and after the agent restarts
+func (s *TenantStore) ReportGauges(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			tenants := make([]*Tenant, 0, len(s.tenants))
+			for _, tenant := range s.tenants {
+				tenants = append(tenants, tenant)
+			}
+			s.mu.Unlock()
+			for _, tenant := range tenants {
+				tenant.lists.ReportGauges()
+			}
+		}
+	}
+}
+
+// Get returns the named tenant, creating it with the default quota if unlisted tenants are allowed
+func (s *TenantStore) Get(name string) (*Tenant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tenants[name]; ok {
+		return t, true
+	}
+	if !s.allowUnlisted {
+		return nil, false
+	}
+	t := newTenant(name, s.defaultQuota)
+	s.tenants[name] = t
+	return t, true
+}
+
+// resolveTenant picks the tenant a request belongs to, the caller's credentials win over the header
+// and it returns false if the header names a different tenant than the credentials
+func resolveTenant(req *http.Request) (string, bool) {
+	header := req.Header.Get(tenantHeader)
+	if identity, ok := identityFromContext(req.Context()); ok {
+		return identity.Tenant, header == "" || header == identity.Tenant
+	}
+	if header != "" {
+		return header, true
+	}
+	return defaultTenantName, true
+}
+
+type tenantKey struct{}
+
+func tenantFromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(*Tenant)
+	return t, ok
+}
+
+// ServeHTTP resolves the request's tenant, applies its rate limit and hands the request to its lists
+func (s *TenantStore) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	name, ok := resolveTenant(req)
+	if !ok {
+		http.Error(res, fmt.Sprintf("Credentials do not belong to tenant %s", req.Header.Get(tenantHeader)), http.StatusForbidden)
+		return
+	}
+	if !listNamePattern.MatchString(name) {
+		http.Error(res, "invalid tenant", http.StatusBadRequest)
+		return
+	}
+	tenant, ok := s.Get(name)
+	if !ok {
+		loggerFromContext(req.Context()).WithField("tenant", name).Warn("Request for unknown tenant")
+		http.Error(res, fmt.Sprintf("Unknown tenant %s", name), http.StatusForbidden)
+		return
+	}
+	// without auth the header alone names the tenant, the auth middleware has not tagged it yet
+	setMetricTenant(req.Context(), name)
+	addLogFields(req.Context(), log.Fields{"tenant": name})
+	if tenant.limiter != nil {
+		r := tenant.limiter.Reserve()
+		if delay := r.Delay(); delay > 0 {
+			r.Cancel()
+			metrics.Count("tenant_rate_limited.count", 1, withGlobalTags("tenant:"+name))
+			loggerFromContext(req.Context()).Warn("Tenant request rate limit exceeded")
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(res, fmt.Sprintf("Rate limit exceeded for tenant %s", name), http.StatusTooManyRequests)
+			return
+		}
+	}
+	ctx := context.WithValue(req.Context(), tenantKey{}, tenant)
+	tenant.lists.ServeHTTP(res, req.WithContext(ctx))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantsCannotSeeEachOthersTasks(t *testing.T) {
+	tenants := NewTenantStore(TenantConfig{AllowUnlisted: true})
+	cases := []struct {
+		tenant, method, url, body, want string
+		respCode                        int
+	}{
+		{"teamA", http.MethodPost, "/tasks/add", `{"id": 1, "title": "secret", "description": "boo1"}`, "Adding the following task to your task list\nTask:\n\tId = 1\n\tTitle = secret\n\tDescription = boo1\n\tCompleted = false", http.StatusCreated},
+		{"teamB", http.MethodGet, "/tasks?showCompleted=true", "", "Getting all tasks...\nThere are no tasks!", http.StatusOK},
+		{"teamB", http.MethodPatch, "/tasks/complete", `{"id": 1}`, "No task with ID = 1 to complete\n", http.StatusOK},
+		{"teamB", http.MethodDelete, "/tasks", "", "", http.StatusNoContent},
+		{"", http.MethodGet, "/tasks?showCompleted=true", "", "Getting all tasks...\nThere are no tasks!", http.StatusOK},
+		{"teamA", http.MethodGet, "/tasks?showCompleted=true", "", "Getting all tasks...\nTask:\n\tId = 1\n\tTitle = secret\n\tDescription = boo1\n\tCompleted = false\n", http.StatusOK},
+		{"../teamA", http.MethodGet, "/tasks?showCompleted=true", "", "invalid tenant\n", http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, bytes.NewBufferString(c.body))
+		if c.tenant != "" {
+			req.Header.Set(tenantHeader, c.tenant)
+		}
+		w := httptest.NewRecorder()
+		tenants.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, c.want, string(data))
+		assert.Equal(t, c.respCode, res.StatusCode)
+	}
+}
+
+func TestUnlistedTenantRejected(t *testing.T) {
+	tenants := NewTenantStore(TenantConfig{Tenants: map[string]TenantQuota{"teamA": {}}})
+	req := httptest.NewRequest(http.MethodGet, "/tasks?showCompleted=true", nil)
+	req.Header.Set(tenantHeader, "teamB")
+	w := httptest.NewRecorder()
+	tenants.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "Unknown tenant teamB\n", w.Body.String())
+}
+
+func TestTenantTaskQuota(t *testing.T) {
+	tenants := NewTenantStore(TenantConfig{Tenants: map[string]TenantQuota{"teamA": {MaxTasks: 1}}})
+	cases := []struct {
+		method, url, body string
+		respCode          int
+	}{
+		{http.MethodPost, "/tasks/add", `{"id": 1, "title": "task1"}`, http.StatusCreated},
+		{http.MethodPost, "/tasks/add", `{"id": 2, "title": "task2"}`, http.StatusForbidden},
+		{http.MethodDelete, "/tasks", "", http.StatusNoContent},
+		{http.MethodPost, "/tasks/add", `{"id": 3, "title": "task3"}`, http.StatusCreated},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, bytes.NewBufferString(c.body))
+		req.Header.Set(tenantHeader, "teamA")
+		w := httptest.NewRecorder()
+		tenants.ServeHTTP(w, req)
+		assert.Equal(t, c.respCode, w.Code)
+	}
+}
+
+func TestTenantRateLimit(t *testing.T) {
+	tenants := NewTenantStore(TenantConfig{Tenants: map[string]TenantQuota{"teamA": {RequestsPerSecond: 0.01, Burst: 2}}})
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/tasks?showCompleted=true", nil)
+		req.Header.Set(tenantHeader, "teamA")
+		w := httptest.NewRecorder()
+		tenants.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests {
+			assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		}
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
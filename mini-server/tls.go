@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSOptions says where the serving certificate and the client CA bundle live on disk
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // enables mTLS when set
+}
+
+// certReloader serves the certificate and client CAs from disk and picks up changes without a restart
+type certReloader struct {
+	opts TLSOptions
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+func newCertReloader(opts TLSOptions) (*certReloader, error) {
+	r := &certReloader{opts: opts}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the certificate, key and CA bundle, leaving the old ones in place on any error
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("reading client CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("client CA bundle contains no certificates")
+		}
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports whether any of the files were modified since the last load
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			// a file mid-rotation can briefly be missing, try again next tick
+			return false
+		}
+		if !info.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch polls the files every interval and reloads them when they change, until stop is closed
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				log.WithFields(standardFields).WithError(err).Error("Failed to reload TLS certificates, keeping the current ones")
+				continue
+			}
+			log.WithFields(standardFields).Info("Reloaded TLS certificates")
+		}
+	}
+}
+
+// Ready fails once the serving certificate has expired and a renewed one has not been picked up
+func (r *certReloader) Ready() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	leaf, err := x509.ParseCertificate(r.cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("TLS certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig builds a server config that always hands out the latest certificate and client CAs
+func (r *certReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: r.getCertificate, NextProtos: []string{"h2", "http/1.1"}}
+	if r.opts.ClientCAFile == "" {
+		return base
+	}
+	// the returned config replaces the one the server serves with, so it carries the ALPN
+	// protocols too or HTTP/2 would be lost under mTLS
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: r.getCertificate,
+			ClientAuth:     tls.RequireAndVerifyClientCert,
+			ClientCAs:      r.clientCAs,
+			NextProtos:     append([]string{}, base.NextProtos...),
+		}, nil
+	}
+	return base
+}
+
+// certIdentity maps a verified client certificate to an identity: the common name is the subject
+// and the first organizational unit, if any, is the tenant
+func certIdentity(req *http.Request) (*Identity, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	subject := req.TLS.VerifiedChains[0][0].Subject
+	identity := &Identity{
+		Subject:    "cert:" + subject.CommonName,
+		Tenant:     defaultTenantName,
+		Scopes:     []string{scopeTasksWrite},
+		AuthMethod: "mtls",
+	}
+	if len(subject.OrganizationalUnit) > 0 {
+		identity.Tenant = subject.OrganizationalUnit[0]
+	}
+	return identity, true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate signed by parent, or a self-signed CA when parent is nil
+func issue(t *testing.T, parent *testCert, subject pkix.Name, serial int64) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certPath, keyPath string) {
+	assert.Nil(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600))
+	if keyPath != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600))
+	}
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// serveTLS runs handler behind the reloader's TLS config and returns its base URL
+func serveTLS(t *testing.T, certs *certReloader, handler http.Handler) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &http.Server{Handler: handler, TLSConfig: certs.TLSConfig()}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+func tlsClient(ca *testCert, clientCert *testCert) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: pool}
+	if clientCert != nil {
+		config.Certificates = []tls.Certificate{clientCert.tlsCert()}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: 5 * time.Second}
+}
+
+func TestMutualTLSMapsCertificateToIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, nil, pkix.Name{CommonName: "test CA"}, 1)
+	serverCert := issue(t, ca, pkix.Name{CommonName: "task-manager"}, 2)
+	aliceCert := issue(t, ca, pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"teamA"}}, 3)
+	rogueCA := issue(t, nil, pkix.Name{CommonName: "rogue CA"}, 4)
+	rogueCert := issue(t, rogueCA, pkix.Name{CommonName: "mallory"}, 5)
+	opts := TLSOptions{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key"), ClientCAFile: filepath.Join(dir, "ca.crt")}
+	serverCert.write(t, opts.CertFile, opts.KeyFile)
+	ca.write(t, opts.ClientCAFile, "")
+
+	certs, err := newCertReloader(opts)
+	assert.Nil(t, err)
+	keys, _ := LoadKeyStore("")
+	auth := NewAuthenticator(keys, nil, true)
+	url := serveTLS(t, certs, auth.Middleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		identity, _ := identityFromContext(req.Context())
+		res.Write([]byte(identity.Subject + " " + identity.Tenant))
+	})))
+
+	resp, err := tlsClient(ca, aliceCert).Get(url + "/tasks")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "cert:alice teamA", string(data))
+
+	_, err = tlsClient(ca, nil).Get(url + "/tasks")
+	assert.NotNil(t, err)
+	_, err = tlsClient(ca, rogueCert).Get(url + "/tasks")
+	assert.NotNil(t, err)
+}
+
+func TestCertificateHotReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, nil, pkix.Name{CommonName: "test CA"}, 1)
+	opts := TLSOptions{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")}
+	issue(t, ca, pkix.Name{CommonName: "first"}, 10).write(t, opts.CertFile, opts.KeyFile)
+
+	certs, err := newCertReloader(opts)
+	assert.Nil(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	go certs.watch(10*time.Millisecond, stop)
+	url := serveTLS(t, certs, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	servedCN := func() string {
+		c := tlsClient(ca, nil)
+		c.Transport.(*http.Transport).DisableKeepAlives = true
+		resp, err := c.Get(url)
+		if err != nil {
+			return err.Error()
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "first", servedCN())
+
+	issue(t, ca, pkix.Name{CommonName: "second"}, 11).write(t, opts.CertFile, opts.KeyFile)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(opts.CertFile, later, later)
+	assert.Eventually(t, func() bool { return servedCN() == "second" }, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestMutualTLSKeepsHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, nil, pkix.Name{CommonName: "test CA"}, 1)
+	serverCert := issue(t, ca, pkix.Name{CommonName: "task-manager"}, 2)
+	aliceCert := issue(t, ca, pkix.Name{CommonName: "alice"}, 3)
+	opts := TLSOptions{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key"), ClientCAFile: filepath.Join(dir, "ca.crt")}
+	serverCert.write(t, opts.CertFile, opts.KeyFile)
+	ca.write(t, opts.ClientCAFile, "")
+	certs, err := newCertReloader(opts)
+	assert.Nil(t, err)
+	url := serveTLS(t, certs, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	for _, protos := range [][]string{{"h2", "http/1.1"}, {"http/1.1"}} {
+		conn, err := tls.Dial("tcp", strings.TrimPrefix(url, "https://"), &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{aliceCert.tlsCert()}, NextProtos: protos})
+		if !assert.Nil(t, err, protos) {
+			continue
+		}
+		assert.Equal(t, protos[0], conn.ConnectionState().NegotiatedProtocol)
+		conn.Close()
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// Tracer traces requests and the work done inside them, backed by Datadog or OpenTelemetry
+type Tracer interface {
+	// Middleware starts a server span per request to route, continuing any incoming trace
+	Middleware(route string, next http.Handler) http.Handler
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+	// TraceID returns the id of the trace in ctx for log correlation, or "" if there is none
+	TraceID(ctx context.Context) string
+	Stop()
+}
+
+// Span is a single unit of work within a trace
+type Span interface {
+	SetTag(key string, value interface{})
+	Finish()
+}
+
+// TracingOptions configures whichever tracer NewTracer builds
+type TracingOptions struct {
+	Service      string
+	Env          string
+	OTLPEndpoint string
+}
+
+// NewTracer builds the named tracer: datadog, otel or none
+func NewTracer(backend string, opts TracingOptions) (Tracer, error) {
+	switch backend {
+	case "datadog":
+		tracer.Start(tracer.WithService(opts.Service), tracer.WithEnv(opts.Env))
+		return &datadogTracer{service: opts.Service}, nil
+	case "otel":
+		return newOTelTracer(opts), nil
+	case "none":
+		return noopTracer{}, nil
+	}
+	return nil, fmt.Errorf("unknown tracing backend %q", backend)
+}
+
+// startSpan starts a child span of whatever span is in ctx
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	return tracing.StartSpan(ctx, name)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Middleware(route string, next http.Handler) http.Handler { return next }
+func (noopTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+func (noopTracer) TraceID(context.Context) string { return "" }
+func (noopTracer) Stop()                          {}
+
+type noopSpan struct{}
+
+func (noopSpan) SetTag(string, interface{}) {}
+func (noopSpan) Finish()                    {}
+
+// datadogTracer sends traces to the Datadog agent, it accepts both Datadog and W3C traceparent headers
+type datadogTracer struct {
+	service string
+}
+
+func (t *datadogTracer) Middleware(route string, next http.Handler) http.Handler {
+	return httptrace.WrapHandler(next, t.service, route, httptrace.WithResourceNamer(func(req *http.Request) string {
+		return req.Method + " " + route
+	}))
+}
+
+func (t *datadogTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	span, ctx := tracer.StartSpanFromContext(ctx, name)
+	return ctx, datadogSpan{span}
+}
+
+func (t *datadogTracer) TraceID(ctx context.Context) string {
+	span, ok := tracer.SpanFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(span.Context().TraceID(), 10)
+}
+
+func (t *datadogTracer) Stop() {
+	tracer.Stop()
+}
+
+type datadogSpan struct {
+	span ddtrace.Span
+}
+
+func (s datadogSpan) SetTag(key string, value interface{}) {
+	s.span.SetTag(key, value)
+}
+
+func (s datadogSpan) Finish() {
+	s.span.Finish()
+}
@@ -0,0 +1,299 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportInterval is how often spans and metrics are pushed to the collector
+const ExportInterval = 5 * time.Second
+
+// OTLP status code of a failed span and aggregation temporality of the metrics
+const (
+	statusCodeError       = 2
+	aggregationCumulative = 2
+)
+
+// Exporter posts OTLP/HTTP JSON payloads to a collector
+type Exporter struct {
+	endpoint string
+	resource OTLPResource
+	scope    OTLPScope
+	client   *http.Client
+}
+
+// NewExporter exports to the collector at endpoint on behalf of service, which also names the
+// instrumentation scope. tags are key:value resource attributes, environment is sent as
+// deployment.environment.
+func NewExporter(endpoint string, service string, tags []string) *Exporter {
+	resource := OTLPResource{Attributes: []OTLPAttribute{Attribute("service.name", service)}}
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		if key == "environment" {
+			key = "deployment.environment"
+		}
+		resource.Attributes = append(resource.Attributes, Attribute(key, value))
+	}
+	return &Exporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		resource: resource,
+		scope:    OTLPScope{Name: service},
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (e *Exporter) post(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding, see opentelemetry-proto. Ids are hex and 64 bit integers are strings.
+
+type OTLPValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type OTLPAttribute struct {
+	Key   string    `json:"key"`
+	Value OTLPValue `json:"value"`
+}
+
+type OTLPResource struct {
+	Attributes []OTLPAttribute `json:"attributes"`
+}
+
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+type OTLPStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type OTLPSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []OTLPAttribute `json:"attributes,omitempty"`
+	Status            OTLPStatus      `json:"status"`
+}
+
+type OTLPScopeSpans struct {
+	Scope OTLPScope  `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+type OTLPResourceSpans struct {
+	Resource   OTLPResource     `json:"resource"`
+	ScopeSpans []OTLPScopeSpans `json:"scopeSpans"`
+}
+
+type OTLPTracesRequest struct {
+	ResourceSpans []OTLPResourceSpans `json:"resourceSpans"`
+}
+
+type OTLPNumberPoint struct {
+	Attributes        []OTLPAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type OTLPHistogramPoint struct {
+	Attributes        []OTLPAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type OTLPGauge struct {
+	DataPoints []OTLPNumberPoint `json:"dataPoints"`
+}
+
+type OTLPSum struct {
+	DataPoints             []OTLPNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type OTLPHistogram struct {
+	DataPoints             []OTLPHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type OTLPMetric struct {
+	Name      string         `json:"name"`
+	Gauge     *OTLPGauge     `json:"gauge,omitempty"`
+	Sum       *OTLPSum       `json:"sum,omitempty"`
+	Histogram *OTLPHistogram `json:"histogram,omitempty"`
+}
+
+type OTLPScopeMetrics struct {
+	Scope   OTLPScope    `json:"scope"`
+	Metrics []OTLPMetric `json:"metrics"`
+}
+
+type OTLPResourceMetrics struct {
+	Resource     OTLPResource       `json:"resource"`
+	ScopeMetrics []OTLPScopeMetrics `json:"scopeMetrics"`
+}
+
+type OTLPMetricsRequest struct {
+	ResourceMetrics []OTLPResourceMetrics `json:"resourceMetrics"`
+}
+
+// Attribute encodes a tag value as the matching OTLP value, unknown types as their string
+func Attribute(key string, value interface{}) OTLPAttribute {
+	var v OTLPValue
+	switch val := value.(type) {
+	case string:
+		v.StringValue = &val
+	case bool:
+		v.BoolValue = &val
+	case int:
+		s := strconv.Itoa(val)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(val, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &val
+	default:
+		s := fmt.Sprint(val)
+		v.StringValue = &s
+	}
+	return OTLPAttribute{Key: key, Value: v}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// spanKinds maps the span.kind tag to the OTLP kind, the tag overrides the kind a span started with
+var spanKinds = map[interface{}]int{"internal": SpanKindInternal, "server": SpanKindServer, "client": SpanKindClient}
+
+// ExportSpans sends finished spans to the collector
+func (e *Exporter) ExportSpans(spans []*Span) error {
+	out := make([]OTLPSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := OTLPSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for k, v := range s.attrs {
+			if kind, ok := spanKinds[v]; ok && k == "span.kind" {
+				span.Kind = kind
+				continue
+			}
+			span.Attributes = append(span.Attributes, Attribute(k, v))
+		}
+		if s.err != "" {
+			span.Status = OTLPStatus{Code: statusCodeError, Message: s.err}
+		}
+		s.mu.Unlock()
+		out = append(out, span)
+	}
+	return e.post("/v1/traces", OTLPTracesRequest{ResourceSpans: []OTLPResourceSpans{{
+		Resource:   e.resource,
+		ScopeSpans: []OTLPScopeSpans{{Scope: e.scope, Spans: out}},
+	}}})
+}
+
+func tagAttributes(tags []string) []OTLPAttribute {
+	attrs := make([]OTLPAttribute, 0, len(tags))
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		attrs = append(attrs, Attribute(key, value))
+	}
+	return attrs
+}
+
+// ExportMetrics sends every series of m as cumulative data points, counted from start
+func (e *Exporter) ExportMetrics(m *PrometheusMetrics, start time.Time, now time.Time) error {
+	return e.post("/v1/metrics", e.metricsRequest(m, start, now))
+}
+
+func (e *Exporter) metricsRequest(m *PrometheusMetrics, start time.Time, now time.Time) OTLPMetricsRequest {
+	from, ts := unixNano(start), unixNano(now)
+	var out []OTLPMetric
+	for _, s := range m.Snapshot() {
+		if len(out) == 0 || out[len(out)-1].Name != s.Name {
+			metric := OTLPMetric{Name: s.Name}
+			switch s.Kind {
+			case "gauge":
+				metric.Gauge = &OTLPGauge{}
+			case "counter":
+				metric.Sum = &OTLPSum{AggregationTemporality: aggregationCumulative, IsMonotonic: true}
+			case "histogram":
+				metric.Histogram = &OTLPHistogram{AggregationTemporality: aggregationCumulative}
+			}
+			out = append(out, metric)
+		}
+		metric := &out[len(out)-1]
+		attrs := tagAttributes(s.Tags)
+		switch s.Kind {
+		case "gauge":
+			metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, OTLPNumberPoint{Attributes: attrs, TimeUnixNano: ts, AsDouble: s.Value})
+		case "counter":
+			metric.Sum.DataPoints = append(metric.Sum.DataPoints, OTLPNumberPoint{Attributes: attrs, StartTimeUnixNano: from, TimeUnixNano: ts, AsDouble: s.Value})
+		case "histogram":
+			// our buckets are cumulative like Prometheus, OTLP wants the count of each bucket
+			counts := make([]string, 0, len(s.Buckets)+1)
+			var below uint64
+			for _, c := range s.Buckets {
+				counts = append(counts, strconv.FormatUint(c-below, 10))
+				below = c
+			}
+			counts = append(counts, strconv.FormatUint(s.Count-below, 10))
+			metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, OTLPHistogramPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: from,
+				TimeUnixNano:      ts,
+				Count:             strconv.FormatUint(s.Count, 10),
+				Sum:               s.Sum,
+				BucketCounts:      counts,
+				ExplicitBounds:    DefaultBuckets,
+			})
+		}
+	}
+	return OTLPMetricsRequest{ResourceMetrics: []OTLPResourceMetrics{{
+		Resource:     e.resource,
+		ScopeMetrics: []OTLPScopeMetrics{{Scope: e.scope, Metrics: out}},
+	}}}
+}
@@ -0,0 +1,155 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collector is a fake OTLP/HTTP endpoint that keeps every payload it receives
+type collector struct {
+	mu       sync.Mutex
+	payloads map[string][][]byte
+}
+
+func newCollector(t *testing.T) (*collector, string) {
+	c := &collector{payloads: make(map[string][][]byte)}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		buf.ReadFrom(req.Body)
+		c.mu.Lock()
+		c.payloads[req.URL.Path] = append(c.payloads[req.URL.Path], buf.Bytes())
+		c.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func (c *collector) traces(t *testing.T) []OTLPTracesRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var reqs []OTLPTracesRequest
+	for _, payload := range c.payloads["/v1/traces"] {
+		var req OTLPTracesRequest
+		assert.Nil(t, json.Unmarshal(payload, &req))
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	for _, header := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		_, ok := ParseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+}
+
+func TestTracerExportsSpans(t *testing.T) {
+	c, endpoint := newCollector(t)
+	tracer := NewTracer(NewExporter(endpoint, "task-client", []string{"environment:test", "team:tasks"}), nil)
+
+	ctx, parent := tracer.Start(context.Background(), "tasks add", SpanKindInternal, nil)
+	_, child := tracer.Start(ctx, "POST /tasks/add", SpanKindInternal, nil)
+	child.SetTag("span.kind", "client")
+	child.SetTag("task.id", int64(7))
+	child.SetError(errors.New("boom"))
+	child.Finish()
+	parent.Finish()
+	tracer.Stop()
+
+	reqs := c.traces(t)
+	if !assert.Len(t, reqs, 1) {
+		return
+	}
+	rs := reqs[0].ResourceSpans[0]
+	assert.Equal(t, []OTLPAttribute{
+		Attribute("service.name", "task-client"),
+		Attribute("deployment.environment", "test"),
+		Attribute("team", "tasks"),
+	}, rs.Resource.Attributes)
+	assert.Equal(t, "task-client", rs.ScopeSpans[0].Scope.Name)
+	spans := rs.ScopeSpans[0].Spans
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, SpanKindClient, spans[0].Kind)
+		assert.Equal(t, []OTLPAttribute{Attribute("task.id", int64(7))}, spans[0].Attributes)
+		assert.Equal(t, OTLPStatus{Code: statusCodeError, Message: "boom"}, spans[0].Status)
+		assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+		assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+		assert.Empty(t, spans[1].ParentSpanID)
+	}
+}
+
+func TestTracerContinuesRemoteContext(t *testing.T) {
+	c, endpoint := newCollector(t)
+	tracer := NewTracer(NewExporter(endpoint, "task-manager", nil), nil)
+
+	sampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tracer.Start(context.Background(), "GET /tasks", SpanKindServer, &sampled)
+	span.Finish()
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span = tracer.Start(context.Background(), "GET /tasks", SpanKindServer, &unsampled)
+	span.Finish()
+	tracer.Stop()
+
+	reqs := c.traces(t)
+	if assert.Len(t, reqs, 1) && assert.Len(t, reqs[0].ResourceSpans[0].ScopeSpans[0].Spans, 1) {
+		span := reqs[0].ResourceSpans[0].ScopeSpans[0].Spans[0]
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID)
+		assert.Equal(t, SpanKindServer, span.Kind)
+	}
+}
+
+func TestExportMetrics(t *testing.T) {
+	c, endpoint := newCollector(t)
+	m := NewPrometheusMetrics()
+	m.Gauge("num_total_tasks.gauge", 4, []string{"list:default"})
+	m.Count("auth_failures.count", 2, []string{"reason:missing"})
+	m.Histogram("http.request.duration_seconds", 0.02, nil)
+	m.Histogram("http.request.duration_seconds", 20, nil)
+	assert.Nil(t, NewExporter(endpoint, "task-manager", nil).ExportMetrics(m, time.Now(), time.Now()))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.Len(t, c.payloads["/v1/metrics"], 1)
+	var req OTLPMetricsRequest
+	assert.Nil(t, json.Unmarshal(c.payloads["/v1/metrics"][0], &req))
+	assert.Equal(t, "task-manager", req.ResourceMetrics[0].ScopeMetrics[0].Scope.Name)
+	byName := make(map[string]OTLPMetric)
+	for _, metric := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		byName[metric.Name] = metric
+	}
+
+	gauge := byName["num_total_tasks"].Gauge.DataPoints[0]
+	assert.Equal(t, 4.0, gauge.AsDouble)
+	assert.Equal(t, []OTLPAttribute{Attribute("list", "default")}, gauge.Attributes)
+
+	sum := byName["auth_failures"].Sum
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t, 2.0, sum.DataPoints[0].AsDouble)
+
+	hist := byName["http.request.duration_seconds"].Histogram.DataPoints[0]
+	assert.Equal(t, "2", hist.Count)
+	assert.Equal(t, []string{"0", "0", "1", "0", "0", "0", "0", "0", "0", "0", "0", "1"}, hist.BucketCounts)
+	assert.Equal(t, DefaultBuckets, hist.ExplicitBounds)
+}
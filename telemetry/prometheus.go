@@ -0,0 +1,245 @@
+// Package telemetry holds the metrics and tracing code task-manager and its client share
+package telemetry
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the latency buckets in seconds, the Prometheus client defaults
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type promSeries struct {
+	tags    []string
+	labels  string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type promFamily struct {
+	kind   string
+	source string // the dotted name without its .gauge/.count suffix
+	series map[string]*promSeries
+}
+
+// PrometheusMetrics keeps metrics in memory and serves them in the Prometheus text format. Names
+// use the DogStatsD dotted style and tags are key:value.
+type PrometheusMetrics struct {
+	mu       sync.Mutex
+	families map[string]*promFamily
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{families: make(map[string]*promFamily)}
+}
+
+func trimKindSuffix(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".gauge"), ".count")
+}
+
+// promName maps a dotted name to a Prometheus one, dropping the .gauge/.count suffix and
+// adding _total to counters
+func promName(name string, kind string) string {
+	name = sanitizeMetricName(trimKindSuffix(name))
+	if kind == "counter" {
+		name += "_total"
+	}
+	return name
+}
+
+func sanitizeMetricName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// promLabels turns key:value tags into a sorted label set
+func promLabels(tags []string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		pairs = append(pairs, sanitizeMetricName(key)+"="+strconv.Quote(value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *PrometheusMetrics) series(name string, kind string, tags []string) *promSeries {
+	key := promName(name, kind)
+	f, ok := m.families[key]
+	if !ok {
+		f = &promFamily{kind: kind, source: trimKindSuffix(name), series: make(map[string]*promSeries)}
+		m.families[key] = f
+	}
+	labels := promLabels(tags)
+	s, ok := f.series[labels]
+	if !ok {
+		s = &promSeries{tags: append([]string{}, tags...), labels: labels}
+		if kind == "histogram" {
+			s.buckets = make([]uint64, len(DefaultBuckets))
+		}
+		f.series[labels] = s
+	}
+	return s
+}
+
+func (m *PrometheusMetrics) Gauge(name string, value float64, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name, "gauge", tags).value = value
+}
+
+func (m *PrometheusMetrics) Count(name string, value int64, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name, "counter", tags).value += float64(value)
+}
+
+func (m *PrometheusMetrics) Histogram(name string, value float64, tags []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.series(name, "histogram", tags)
+	for i, bound := range DefaultBuckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Close does nothing, the metrics only live in memory
+func (m *PrometheusMetrics) Close() error {
+	return nil
+}
+
+// Series is a snapshot of one series
+type Series struct {
+	Name    string // the dotted name without its .gauge/.count suffix
+	Kind    string // gauge, counter or histogram
+	Tags    []string
+	Value   float64  // of a gauge or counter
+	Buckets []uint64 // of a histogram, the cumulative counts of DefaultBuckets
+	Sum     float64
+	Count   uint64
+}
+
+// Snapshot returns every series, sorted by name and labels
+func (m *PrometheusMetrics) Snapshot() []Series {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Series
+	for _, name := range m.names() {
+		f := m.families[name]
+		for _, k := range f.keys() {
+			s := f.series[k]
+			series := Series{Name: f.source, Kind: f.kind, Tags: append([]string{}, s.tags...), Value: s.value, Sum: s.sum, Count: s.count}
+			if s.buckets != nil {
+				series.Buckets = append([]uint64{}, s.buckets...)
+			}
+			out = append(out, series)
+		}
+	}
+	return out
+}
+
+// names returns the family names sorted, callers must hold m.mu
+func (m *PrometheusMetrics) names() []string {
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *promFamily) keys() []string {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func withLabel(labels string, label string) string {
+	if labels == "" {
+		return "{" + label + "}"
+	}
+	return "{" + labels + "," + label + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// promFamilyCopy is a family and its series copied out of m.mu, sorted by labels
+type promFamilyCopy struct {
+	name   string
+	kind   string
+	series []promSeries
+}
+
+// copyFamilies copies every family sorted by name, so that they can be written without holding
+// m.mu while a slow scraper reads
+func (m *PrometheusMetrics) copyFamilies() []promFamilyCopy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]promFamilyCopy, 0, len(m.families))
+	for _, name := range m.names() {
+		f := m.families[name]
+		c := promFamilyCopy{name: name, kind: f.kind, series: make([]promSeries, 0, len(f.series))}
+		for _, k := range f.keys() {
+			s := *f.series[k]
+			if s.buckets != nil {
+				s.buckets = append([]uint64{}, s.buckets...)
+			}
+			c.series = append(c.series, s)
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// handler for /metrics
+func (m *PrometheusMetrics) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	families := m.copyFamilies()
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	for _, f := range families {
+		name := f.name
+		fmt.Fprintf(res, "# TYPE %s %s\n", name, f.kind)
+		for _, s := range f.series {
+			labels := ""
+			if s.labels != "" {
+				labels = "{" + s.labels + "}"
+			}
+			if f.kind != "histogram" {
+				fmt.Fprintf(res, "%s%s %s\n", name, labels, formatFloat(s.value))
+				continue
+			}
+			for i, bound := range DefaultBuckets {
+				fmt.Fprintf(res, "%s_bucket%s %d\n", name, withLabel(s.labels, `le="`+formatFloat(bound)+`"`), s.buckets[i])
+			}
+			fmt.Fprintf(res, "%s_bucket%s %d\n", name, withLabel(s.labels, `le="+Inf"`), s.count)
+			fmt.Fprintf(res, "%s_sum%s %s\n", name, labels, formatFloat(s.sum))
+			fmt.Fprintf(res, "%s_count%s %d\n", name, labels, s.count)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package telemetry
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(m *PrometheusMetrics) string {
+	res := httptest.NewRecorder()
+	m.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	return res.Body.String()
+}
+
+func TestPrometheusGaugesAndCounters(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.Gauge("num_total_tasks.gauge", 3, []string{"tenant:acme", "list:default"})
+	m.Gauge("num_total_tasks.gauge", 5, []string{"list:default", "tenant:acme"})
+	m.Count("auth_failures.count", 1, []string{"reason:missing"})
+	m.Count("auth_failures.count", 2, []string{"reason:missing"})
+
+	body := scrape(m)
+	assert.Contains(t, body, "# TYPE num_total_tasks gauge\nnum_total_tasks{list=\"default\",tenant=\"acme\"} 5\n")
+	assert.Contains(t, body, "# TYPE auth_failures_total counter\nauth_failures_total{reason=\"missing\"} 3\n")
+}
+
+func TestPrometheusHistogram(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.Histogram("http.request.duration_seconds", 0.02, []string{"route:/tasks"})
+	m.Histogram("http.request.duration_seconds", 3, []string{"route:/tasks"})
+
+	body := scrape(m)
+	assert.Contains(t, body, "# TYPE http_request_duration_seconds histogram\n")
+	assert.Contains(t, body, `http_request_duration_seconds_bucket{route="/tasks",le="0.01"} 0`)
+	assert.Contains(t, body, `http_request_duration_seconds_bucket{route="/tasks",le="0.025"} 1`)
+	assert.Contains(t, body, `http_request_duration_seconds_bucket{route="/tasks",le="5"} 2`)
+	assert.Contains(t, body, `http_request_duration_seconds_bucket{route="/tasks",le="+Inf"} 2`)
+	assert.Contains(t, body, `http_request_duration_seconds_sum{route="/tasks"} 3.02`)
+	assert.Contains(t, body, `http_request_duration_seconds_count{route="/tasks"} 2`)
+}
+
+// stalledWriter blocks its first write until release is closed, like a scraper that stops reading
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	select {
+	case <-w.writing:
+	default:
+		close(w.writing)
+		<-w.release
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestPrometheusSlowScrapeDoesNotBlockRecording(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.Gauge("num_total_tasks.gauge", 3, nil)
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	scraped := make(chan struct{})
+	go func() {
+		m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		close(scraped)
+	}()
+	<-w.writing
+
+	recorded := make(chan struct{})
+	go func() {
+		m.Gauge("num_total_tasks.gauge", 4, nil)
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Error("recording a metric waited for the scrape to be written")
+	}
+	close(w.release)
+	<-scraped
+	assert.Contains(t, w.Body.String(), "num_total_tasks 3\n")
+}
+
+func TestPrometheusSnapshot(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.Count("auth_failures.count", 2, []string{"reason:missing"})
+	m.Histogram("http.request.duration_seconds", 0.02, nil)
+
+	assert.Equal(t, []Series{
+		{Name: "auth_failures", Kind: "counter", Tags: []string{"reason:missing"}, Value: 2},
+		{Name: "http.request.duration_seconds", Kind: "histogram", Tags: []string{}, Buckets: []uint64{0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1}, Sum: 0.02, Count: 1},
+	}, m.Snapshot())
+}
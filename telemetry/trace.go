@@ -0,0 +1,208 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader carries the W3C trace context between processes
+const TraceparentHeader = "traceparent"
+
+// OTLP span kinds
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+)
+
+const maxPendingSpans = 2048
+
+// SpanContext is the part of a span that crosses process boundaries in a traceparent header
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// ParseTraceparent reads a version 00 traceparent header, all-zero ids are invalid
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || !sc.IsValid() {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent encodes the context as a version 00 traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+type spanKey struct{}
+
+// Span is an operation sent to the collector once finished, a span.kind tag of internal, server
+// or client overrides the kind it started with
+type Span struct {
+	tracer   *Tracer
+	context  SpanContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs map[string]interface{}
+	err   string
+	end   time.Time
+	ended bool
+}
+
+func (s *Span) SetTag(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// SpanFromContext returns the span started by Start that ctx carries
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	return span, ok
+}
+
+// Tracer batches finished spans and exports them to an OTLP/HTTP collector
+type Tracer struct {
+	exporter *Exporter
+	onError  func(err error, spans int)
+
+	mu      sync.Mutex
+	pending []*Span
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewTracer exports every ExportInterval until stopped, onError is told about batches that
+// could not be exported
+func NewTracer(exporter *Exporter, onError func(err error, spans int)) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		onError:  onError,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span as a child of the span in ctx, else of remote, else as the root of a new
+// sampled trace
+func (t *Tracer) Start(ctx context.Context, name string, kind int, remote *SpanContext) (context.Context, *Span) {
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: make(map[string]interface{})}
+	if parent, ok := SpanFromContext(ctx); ok {
+		span.context.TraceID = parent.context.TraceID
+		span.context.Sampled = parent.context.Sampled
+		span.parentID = parent.context.SpanID
+	} else if remote != nil {
+		span.context.TraceID = remote.TraceID
+		span.context.Sampled = remote.Sampled
+		span.parentID = remote.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+	rand.Read(span.context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= maxPendingSpans {
+		// the collector is not keeping up, drop rather than grow without bound
+		return
+	}
+	t.pending = append(t.pending, span)
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(ExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			t.flush()
+			return
+		case <-ticker.C:
+			t.flush()
+		}
+	}
+}
+
+func (t *Tracer) flush() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.exporter.ExportSpans(spans); err != nil && t.onError != nil {
+		t.onError(err, len(spans))
+	}
+}
+
+// Stop exports whatever spans are still pending
+func (t *Tracer) Stop() {
+	close(t.stop)
+	<-t.done
+}